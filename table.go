@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// splitCells splits the rows of a table into cells separated by tabs
+func splitCells(rows []string) [][]string {
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = strings.Split(row, "\t")
+	}
+	return cells
+}
+
+// toCSV returns the rows of a table as comma separated values
+func toCSV(rows []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.WriteAll(splitCells(rows)) // writing to a strings.Builder never fails
+	return b.String()
+}
+
+// alignComments moves the comments, starting with marker in the last cell of
+// rows, to a column of their own after the last column of the table
+func alignComments(rows []string, marker string) {
+	cells := splitCells(rows)
+	comments := make([]string, len(rows))
+	columns := 0
+	for i, row := range cells {
+		last := row[len(row)-1]
+		if j := strings.Index(last, marker); j >= 0 {
+			comments[i] = last[j:]
+			row[len(row)-1] = strings.TrimRight(last[:j], " ")
+			if row[len(row)-1] == "" {
+				row = row[:len(row)-1]
+			}
+			cells[i] = row
+		}
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	for i, row := range cells {
+		if comments[i] == "" {
+			continue
+		}
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		rows[i] = strings.Join(append(row, comments[i]), "\t")
+	}
+}
+
+// justifyLastRow shifts the cells of the last row of a table to the last
+// columns, if it has fewer cells than the other rows, like a row of totals
+func justifyLastRow(rows []string) {
+	if len(rows) < 2 {
+		return
+	}
+	columns := 0
+	for _, row := range rows[:len(rows)-1] {
+		if n := strings.Count(row, "\t") + 1; n > columns {
+			columns = n
+		}
+	}
+	last := len(rows) - 1
+	if n := strings.Count(rows[last], "\t") + 1; n < columns {
+		rows[last] = strings.Repeat("\t", columns-n) + rows[last]
+	}
+}
+
+// tableSummary describes a table for -table-info
+type tableSummary struct {
+	Line    int      `json:"line"`
+	Rows    int      `json:"rows"`
+	Columns int      `json:"columns"`
+	Widths  []int    `json:"widths"`
+	Types   []string `json:"types"`
+}
+
+// describeTable returns the number of rows and columns of a table, and the
+// width and type, numeric or text, of each column. A column is numeric if
+// all its cells, but the empty ones and the header, are numbers
+func describeTable(rows []string, num int, header bool) tableSummary {
+	info := tableSummary{Line: num, Rows: len(rows)}
+	for j, row := range splitCells(rows) {
+		for i, cell := range row {
+			if i == info.Columns {
+				info.Columns++
+				info.Widths = append(info.Widths, 0)
+				info.Types = append(info.Types, "numeric")
+			}
+			if n := utf8.RuneCountInString(cell); n > info.Widths[i] {
+				info.Widths[i] = n
+			}
+			if j == 0 && header {
+				continue
+			}
+			if c := strings.TrimSpace(cell); c != "" {
+				if _, err := strconv.ParseFloat(c, 64); err != nil {
+					info.Types[i] = "text"
+				}
+			}
+		}
+	}
+	return info
+}
+
+// collapseCells drops the empty cells of a row, shifting the next cells to the left
+func collapseCells(row string) string {
+	cells := strings.Split(row, "\t")
+	kept := cells[:0]
+	for _, cell := range cells {
+		if cell != "" {
+			kept = append(kept, cell)
+		}
+	}
+	return strings.Join(kept, "\t")
+}
+
+// cellWidths computes the widths of cells with elastic tabstops as described
+// in http://nickgravgaard.com/elastictabstops/index.html. Each cell of a row,
+// except the last one, belongs to a column block, i.e the cells of the same
+// column in adjacent rows. All the cells of a column block have the width of
+// the widest one, plus padding, but no less than minwidth. A row with fewer
+// cells ends the column block. With gaps, an empty cell ends it too and is a
+// column block of its own, so cells do not align across it, unlike tabwriter.
+// The last cell of a row has no width.
+func cellWidths(cells [][]string, minwidth, padding int, gaps bool) [][]int {
+	widths := make([][]int, len(cells))
+	for i := range cells {
+		widths[i] = make([]int, len(cells[i])-1)
+	}
+
+	for col := 0; ; col++ {
+		gap := func(i int) bool {
+			return gaps && cells[i][col] == ""
+		}
+
+		found := false
+		for start := 0; start < len(cells); start++ {
+			if len(cells[start])-1 <= col {
+				continue
+			}
+			found = true
+
+			if gap(start) {
+				widths[start][col] = minwidth
+				if padding > minwidth {
+					widths[start][col] = padding
+				}
+				continue
+			}
+
+			end, width := start, minwidth
+			for ; end < len(cells) && len(cells[end])-1 > col && !gap(end); end++ {
+				if w := utf8.RuneCountInString(cells[end][col]) + padding; w > width {
+					width = w
+				}
+			}
+			for i := start; i < end; i++ {
+				widths[i][col] = width
+			}
+
+			start = end - 1
+		}
+
+		if !found {
+			return widths
+		}
+	}
+}
+
+// elastic aligns the rows of a table with elastic tabstops, where empty
+// cells break the column blocks
+func elastic(rows []string, minwidth, padding int) string {
+	cells := splitCells(rows)
+	widths := cellWidths(cells, minwidth, padding, true)
+
+	var b strings.Builder
+	for i, row := range cells {
+		for col, cell := range row {
+			b.WriteString(cell)
+			if col < len(widths[i]) {
+				b.WriteString(strings.Repeat(" ", widths[i][col]-utf8.RuneCountInString(cell)))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// withLeaders aligns the rows of a table like elastic, but fills the space before
+// the last cell of each row with leader, between two spaces, like a table of contents
+func withLeaders(rows []string, minwidth int, leader string) string {
+	cells := splitCells(rows)
+	widths := cellWidths(cells, minwidth, 1, false)
+
+	var b strings.Builder
+	for i, row := range cells {
+		for col, cell := range row {
+			b.WriteString(cell)
+			if col >= len(widths[i]) {
+				continue
+			}
+
+			n := widths[i][col] - utf8.RuneCountInString(cell)
+			if col == len(row)-2 {
+				b.WriteString(" " + strings.Repeat(leader, n) + " ")
+			} else {
+				b.WriteString(strings.Repeat(" ", n))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// cellMark marks the start of each cell of the header in the line rendered
+// for underline
+const cellMark = '\x01'
+
+// markCells returns row with each cell replaced by cellMark followed by dashes,
+// as many characters as the cell, or by cellMark alone if the cell is empty
+func markCells(row string) string {
+	cells := strings.Split(row, "\t")
+	for i, cell := range cells {
+		n := utf8.RuneCountInString(cell)
+		if n == 0 {
+			n = 1
+		}
+		cells[i] = string(cellMark) + strings.Repeat("-", n-1)
+	}
+	return strings.Join(cells, "\t")
+}
+
+// underline returns a row of dashes under each column of the first row of the
+// table. header is the first line of the table rendered with the cells of the
+// first row marked by markCells, which shows where each column starts. Each
+// column is underlined up to a space before the next one. The last column is
+// as wide as the widest last cell of the rows that have the same number of
+// columns as the first.
+func underline(cells [][]string, header string) string {
+	last := len(cells[0]) - 1
+	lastWidth := 0
+	for _, row := range cells {
+		if len(row)-1 == last {
+			if w := utf8.RuneCountInString(row[last]); w > lastWidth {
+				lastWidth = w
+			}
+		}
+	}
+
+	var starts []int
+	for i, r := range []rune(header) {
+		if r == cellMark {
+			starts = append(starts, i)
+		}
+	}
+
+	var b strings.Builder
+	col := 0
+	for i, start := range starts {
+		w := lastWidth
+		if i+1 < len(starts) {
+			w = starts[i+1] - start - 1
+		}
+		b.WriteString(strings.Repeat(" ", start-col))
+		b.WriteString(strings.Repeat("-", w))
+		col = start + w
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// alignKeyValues aligns the values of rows of key<tab>value pairs after the
+// longest key, with sep between keys and values. The value is the rest of the
+// row after the first tab, or empty if the row has no tab
+func alignKeyValues(rows []string, sep string) string {
+	width := 0
+	for _, row := range rows {
+		key := strings.SplitN(row, "\t", 2)[0]
+		if w := utf8.RuneCountInString(key); w > width {
+			width = w
+		}
+	}
+
+	var b strings.Builder
+	for _, row := range rows {
+		kv := strings.SplitN(row, "\t", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		value := strings.TrimLeft(kv[1], "\t")
+		aligned := kv[0] + strings.Repeat(" ", width-utf8.RuneCountInString(kv[0])) + sep + value
+		if value == "" {
+			aligned = strings.TrimRight(aligned, " ")
+		}
+		b.WriteString(aligned)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// exportTables writes the tables of lines to buf in format, tsv, csv or json,
+// and omits the rest of the text. Tables are separated by blank lines, except
+// in json, where each table is a JSON value on its own line: an array of rows,
+// which are arrays of cells, or an array of objects if header is set
+func exportTables(lines []*line, format string, header bool, buf *bytes.Buffer) {
+	var tables [][]string
+	var rows []string
+	for _, l := range lines {
+		if isRow(l) {
+			rows = append(rows, l.text)
+			continue
+		}
+		if rows != nil {
+			tables = append(tables, rows)
+			rows = nil
+		}
+	}
+	if rows != nil {
+		tables = append(tables, rows)
+	}
+
+	for i, rows := range tables {
+		switch format {
+		case "tsv":
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(strings.Join(rows, "\n") + "\n")
+		case "csv":
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(toCSV(rows))
+		case "json":
+			var data []byte
+			if header {
+				data = jsonObjects(splitCells(rows))
+			} else {
+				data, _ = json.Marshal(splitCells(rows))
+			}
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// jsonObjects returns the rows after the first as a JSON array of objects
+// whose keys are the cells of the first row, in order. Cells without a key
+// have their column number, starting from 1, as key
+func jsonObjects(cells [][]string) []byte {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, row := range cells[1:] {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('{')
+		for j, cell := range row {
+			key := strconv.Itoa(j + 1)
+			if j < len(cells[0]) {
+				key = cells[0][j]
+			}
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			k, _ := json.Marshal(key)
+			v, _ := json.Marshal(cell)
+			b.Write(k)
+			b.WriteByte(':')
+			b.Write(v)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(']')
+	return b.Bytes()
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCellWidths(t *testing.T) {
+	cells := splitCells([]string{
+		"a\tbb\tc",
+		"aaa\tb",
+		"x",
+		"y\tz",
+	})
+	want := [][]int{
+		{4, 3},
+		{4},
+		{},
+		{2},
+	}
+	if got := cellWidths(cells, 2, 1, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("cellWidths = %v, want %v", got, want)
+	}
+
+	// an empty cell is a gap in the column blocks only with gaps
+	cells = splitCells([]string{
+		"a\tb\tc",
+		"x\t\tc",
+		"aaa\tbbb\tc",
+	})
+	tests := []struct {
+		gaps bool
+		want [][]int
+	}{
+		{false, [][]int{{4, 4}, {4, 4}, {4, 4}}},
+		{true, [][]int{{4, 2}, {4, 1}, {4, 4}}},
+	}
+	for _, tt := range tests {
+		if got := cellWidths(cells, 1, 1, tt.gaps); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cellWidths with gaps %v = %v, want %v", tt.gaps, got, tt.want)
+		}
+	}
+}
+
+func TestElastic(t *testing.T) {
+	tests := []struct {
+		rows              []string
+		minwidth, padding int
+		want              string
+	}{
+		{[]string{"a\tbb\tc", "aaa\tb", "x", "y\tz"}, 1, 1, "a   bb c\naaa b\nx\ny z\n"},
+		{[]string{"a\tb", "aa\tb"}, 4, 1, "a   b\naa  b\n"},
+		{[]string{"a\tb", "aa\tb"}, 1, 2, "a   b\naa  b\n"},
+		{[]string{"αβ\tb", "a\tb"}, 1, 1, "αβ b\na  b\n"},
+		{[]string{"a\t\tb", "aa\tc"}, 1, 1, "a   b\naa c\n"},
+	}
+	for _, tt := range tests {
+		if got := elastic(tt.rows, tt.minwidth, tt.padding); got != tt.want {
+			t.Errorf("elastic(%q, %d, %d) = %q, want %q", tt.rows, tt.minwidth, tt.padding, got, tt.want)
+		}
+	}
+}
+
+func TestElasticStrict(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"column blocks", "a\tbb\tc\naaa\tb\n", []string{"elastic-strict", "true", "t", "1"}, "a   bb c\naaa b\n"},
+		{"minimum width", "a\tb\n", []string{"elastic-strict", "true"}, "a   b\n"},
+		{"empty cell", "a\tb\tc\nx\t\tc\naaa\tbbb\tc\n", []string{"elastic-strict", "true", "t", "1"}, "a   b c\nx    c\naaa bbb c\n"},
+		{"empty cell without -elastic-strict", "a\tb\tc\nx\t\tc\naaa\tbbb\tc\n", []string{"t", "1"}, "a   b   c\nx       c\naaa bbb c\n"},
+	})
+}
+
+func TestUnderline(t *testing.T) {
+	tests := []struct {
+		rows []string
+		want string
+	}{
+		{[]string{"name\tage", "alice\t30", "bob\t4"}, "----- ---\n"},
+		{[]string{"a\tb\tc", "long\tx\ty"}, "---- - -\n"},
+		{[]string{"only", "one"}, "----\n"},
+		{[]string{"\tb", "a\tc"}, "- -\n"},
+	}
+	for _, tt := range tests {
+		marked := elastic(append([]string{markCells(tt.rows[0])}, tt.rows[1:]...), 2, 1)
+		header := strings.SplitN(marked, "\n", 2)[0]
+		if got := underline(splitCells(tt.rows), header); got != tt.want {
+			t.Errorf("underline(%q) = %q, want %q", tt.rows, got, tt.want)
+		}
+	}
+}
+
+func TestUnderlineHeader(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"underline matches the columns", "name\tage\nalice\t30\nbob\t4\n", []string{"underline-header", "true"}, "name  age\n----- ---\nalice 30\nbob   4\n"},
+		{"leaders", "Chapter\tPage\nIntroduction\t1\n", []string{"underline-header", "true", "leader", "."}, "Chapter ...... Page\n-------------- ----\nIntroduction . 1\n"},
+		{"key values", "Chapter\tPage\nIntroduction\t1\n", []string{"underline-header", "true", "kv-equals", "true"}, "Chapter      = Page\n-------------- ----\nIntroduction = 1\n"},
+		{"header only", "name\tage\nalice\t30\n", []string{"header", "true"}, "name  age\nalice 30\n"},
+	})
+}
+
+func TestAlignKeyValues(t *testing.T) {
+	tests := []struct {
+		rows []string
+		sep  string
+		want string
+	}{
+		{[]string{"a\t1", "bbb\t2"}, " ", "a   1\nbbb 2\n"},
+		{[]string{"a\t1", "bbb\t2"}, " = ", "a   = 1\nbbb = 2\n"},
+		{[]string{"key\tvalue\twith tab"}, " = ", "key = value\twith tab\n"},
+		{[]string{"a\t\t\t1", "bb\t2"}, " ", "a  1\nbb 2\n"},
+		{[]string{"a", "bb\tx"}, " ", "a\nbb x\n"},
+		{[]string{"a", "bb\tx"}, " = ", "a  =\nbb = x\n"},
+	}
+	for _, tt := range tests {
+		if got := alignKeyValues(tt.rows, tt.sep); got != tt.want {
+			t.Errorf("alignKeyValues(%q, %q) = %q, want %q", tt.rows, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestKeyValues(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "align",
+			input: "a\t1\nbbb\t2\n",
+			flags: []string{"kv-align", "true"},
+			want:  "a   1\nbbb 2\n",
+		},
+		{
+			name:  "equals",
+			input: "a\t1\nbbb\t2\n",
+			flags: []string{"kv-equals", "true"},
+			want:  "a   = 1\nbbb = 2\n",
+		},
+		{
+			name:  "collapsed empty value",
+			input: "a\t\nbb\tx\n",
+			flags: []string{"kv-align", "true", "empty-cells", "collapse"},
+			want:  "a\nbb x\n",
+		},
+	})
+}
+
+func TestCollapseCells(t *testing.T) {
+	tests := []struct {
+		row, want string
+	}{
+		{"a\tb\tc", "a\tb\tc"},
+		{"a\t\tc", "a\tc"},
+		{"a\t\t\t", "a"},
+		{"a\t", "a"},
+	}
+	for _, tt := range tests {
+		if got := collapseCells(tt.row); got != tt.want {
+			t.Errorf("collapseCells(%q) = %q, want %q", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyCells(t *testing.T) {
+	input := "a\t\tc\ndd\tee\tff\ng\th\t\n"
+	runFormatTests(t, []formatTest{
+		{"keep", input, []string{"empty-cells", "keep"}, "a       c\ndd  ee  ff\ng   h   \n"},
+		{"collapse", input, []string{"empty-cells", "collapse"}, "a   c\ndd  ee  ff\ng   h\n"},
+	})
+}
+
+func TestRenderTabsAsSpaces(t *testing.T) {
+	input := "a\tb\n\tindented text\n"
+	runFormatTests(t, []formatTest{
+		{"-t for both", input, []string{"t", "8"}, "a       b\n        indented text\n"},
+		{"tables only", input, []string{"render-tabs-as-spaces", "2"}, "a b\n    indented text\n"},
+		{"independent of -t", input, []string{"t", "8", "render-tabs-as-spaces", "2"}, "a b\n        indented text\n"},
+		{"wide cells", "abc\td\ne\tf\n", []string{"render-tabs-as-spaces", "2"}, "abc d\ne   f\n"},
+	})
+}
+
+func TestLeader(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"table of contents", "Introduction\t1\nUsage\t12\n", []string{"leader", "."}, "Introduction . 1\nUsage ........ 12\n"},
+		{"only the last column", "1\tIntroduction\t1\n2\tUsage\t12\n", []string{"leader", "."}, "1   Introduction . 1\n2   Usage ........ 12\n"},
+	})
+}
+
+func TestMarkCells(t *testing.T) {
+	tests := []struct {
+		row, want string
+	}{
+		{"name\tage", "\x01---\t\x01--"},
+		{"a\t\tcc", "\x01\t\x01\t\x01-"},
+		{"äö", "\x01-"},
+	}
+	for _, tt := range tests {
+		if got := markCells(tt.row); got != tt.want {
+			t.Errorf("markCells(%q) = %q, want %q", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestTableBlank(t *testing.T) {
+	input := "a\tb\n\ncccccc\td\ntext\n"
+	runFormatTests(t, []formatTest{
+		{"keep", input, []string{"table-blank", "keep"}, "a      b\n\ncccccc d\ntext\n"},
+		{"break", input, []string{"table-blank", "break"}, "a   b\n\ncccccc d\ntext\n"},
+		{"blank lines after the table", "a\tb\n\n\ntext\n", []string{"table-blank", "keep"}, "a   b\n\n\ntext\n"},
+		{"blank lines inside", "a\tb\n\n\ncccccc\td\n", []string{"table-blank", "keep"}, "a      b\n\n\ncccccc d\n"},
+	})
+}
+
+func TestOverflowCSV(t *testing.T) {
+	captureLog(t) // the warnings of -table-length
+	input := "alpha\tbeta\tgamma\n"
+	runFormatTests(t, []formatTest{
+		{"wider than -l", input, []string{"l", "10", "overflow-csv", "true"}, "alpha,beta,gamma\n"},
+		{"fits -l", input, []string{"l", "30", "overflow-csv", "true"}, "alpha beta gamma\n"},
+		{"fits -table-length", input, []string{"l", "10", "table-length", "20", "overflow-csv", "true"}, "alpha beta gamma\n"},
+		{"wider than -table-length", input, []string{"l", "30", "table-length", "12", "overflow-csv", "true"}, "alpha,beta,gamma\n"},
+	})
+}
+
+func TestJustifyLastRow(t *testing.T) {
+	tests := []struct {
+		rows, want []string
+	}{
+		{[]string{"a\tb\tc", "total\t6"}, []string{"a\tb\tc", "\ttotal\t6"}},
+		{[]string{"a\tb\tc", "6"}, []string{"a\tb\tc", "\t\t6"}},
+		{[]string{"a\tb", "c\td"}, []string{"a\tb", "c\td"}},
+		{[]string{"a\tb"}, []string{"a\tb"}},
+	}
+	for _, tt := range tests {
+		rows := append([]string(nil), tt.rows...)
+		justifyLastRow(rows)
+		if !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("justifyLastRow(%q) = %q, want %q", tt.rows, rows, tt.want)
+		}
+	}
+}
+
+func TestJustifyTotal(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"total", "apples\t2\t3\npears\t1\t1\ntotal\t4\n", []string{"justify-total", "true"}, "apples 2     3\npears  1     1\n       total 4\n"},
+		{"without -justify-total", "apples\t2\t3\ntotal\t4\n", nil, "apples 2   3\ntotal  4\n"},
+	})
+}
+
+func TestToCSV(t *testing.T) {
+	tests := []struct {
+		rows []string
+		want string
+	}{
+		{[]string{"a\tb", "c\td"}, "a,b\nc,d\n"},
+		{[]string{"x, y\t\"q\""}, "\"x, y\",\"\"\"q\"\"\"\n"},
+		{[]string{"a"}, "a\n"},
+	}
+	for _, tt := range tests {
+		if got := toCSV(tt.rows); got != tt.want {
+			t.Errorf("toCSV(%q) = %q, want %q", tt.rows, got, tt.want)
+		}
+	}
+}
+
+func TestJSONObjects(t *testing.T) {
+	tests := []struct {
+		cells [][]string
+		want  string
+	}{
+		{[][]string{{"name", "age"}, {"ann", "30"}, {"bob", "4"}}, `[{"name":"ann","age":"30"},{"name":"bob","age":"4"}]`},
+		{[][]string{{"name"}, {"ann", "30"}}, `[{"name":"ann","2":"30"}]`},
+		{[][]string{{"name", "age"}}, `[]`},
+	}
+	for _, tt := range tests {
+		if got := string(jsonObjects(tt.cells)); got != tt.want {
+			t.Errorf("jsonObjects(%q) = %s, want %s", tt.cells, got, tt.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	input := "some text\n\na\tb\nc\td\n\nmore text\n\ne\tf\n"
+	runFormatTests(t, []formatTest{
+		{"tsv", input, []string{"export", "tsv"}, "a\tb\nc\td\n\ne\tf\n"},
+		{"csv", input, []string{"export", "csv"}, "a,b\nc,d\n\ne,f\n"},
+		{"json", input, []string{"export", "json"}, "[[\"a\",\"b\"],[\"c\",\"d\"]]\n[[\"e\",\"f\"]]\n"},
+		{"json with -header", input, []string{"export", "json", "header", "true"}, "[{\"a\":\"c\",\"b\":\"d\"}]\n[]\n"},
+		{"no tables", "just text\n", []string{"export", "csv"}, ""},
+	})
+}
+
+func TestDescribeTable(t *testing.T) {
+	tests := []struct {
+		rows   []string
+		header bool
+		want   tableSummary
+	}{
+		{[]string{"a\t1", "bb\t22"}, false,
+			tableSummary{Line: 3, Rows: 2, Columns: 2, Widths: []int{2, 2}, Types: []string{"text", "numeric"}}},
+		{[]string{"name\tage", "ann\t30", "bob\t"}, true,
+			tableSummary{Line: 3, Rows: 3, Columns: 2, Widths: []int{4, 3}, Types: []string{"text", "numeric"}}},
+		{[]string{"name\tage", "ann\t30"}, false,
+			tableSummary{Line: 3, Rows: 2, Columns: 2, Widths: []int{4, 3}, Types: []string{"text", "text"}}},
+		{[]string{"x\t-1.5", "y\t2e3\tz"}, false,
+			tableSummary{Line: 3, Rows: 2, Columns: 3, Widths: []int{1, 4, 1}, Types: []string{"text", "numeric", "text"}}},
+		{[]string{"é\t1"}, false,
+			tableSummary{Line: 3, Rows: 1, Columns: 2, Widths: []int{1, 1}, Types: []string{"text", "numeric"}}},
+	}
+	for _, tt := range tests {
+		if got := describeTable(tt.rows, 3, tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("describeTable(%q, %v) = %+v, want %+v", tt.rows, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTableSummaryJSON(t *testing.T) {
+	info := tableSummary{Line: 1, Rows: 2, Columns: 1, Widths: []int{3}, Types: []string{"text"}}
+	want := `{"line":1,"rows":2,"columns":1,"widths":[3],"types":["text"]}`
+	if data, err := json.Marshal(info); err != nil || string(data) != want {
+		t.Errorf("json.Marshal(%+v) = %s, %v, want %s", info, data, err, want)
+	}
+}
+
+func TestAlignComments(t *testing.T) {
+	tests := []struct {
+		rows, want []string
+	}{
+		{[]string{"a\tb # one", "c\td\te # two"}, []string{"a\tb\t\t# one", "c\td\te\t# two"}},
+		{[]string{"a\tb", "c\td # two"}, []string{"a\tb", "c\td\t# two"}},
+		{[]string{"a\t# only a comment", "c\td"}, []string{"a\t\t# only a comment", "c\td"}},
+		{[]string{"a\tb", "c\td"}, []string{"a\tb", "c\td"}},
+	}
+	for _, tt := range tests {
+		rows := append([]string(nil), tt.rows...)
+		alignComments(rows, "#")
+		if !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("alignComments(%q) = %q, want %q", tt.rows, rows, tt.want)
+		}
+	}
+}
+
+func TestTableComments(t *testing.T) {
+	input := "x\t1 # first\nlonger\t2\t3 # second\n"
+	runFormatTests(t, []formatTest{
+		{"aligned", input, []string{"table-comments", "#"}, "x      1       # first\nlonger 2   3   # second\n"},
+		{"without -table-comments", input, nil, "x      1 # first\nlonger 2   3 # second\n"},
+	})
+}
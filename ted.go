@@ -2,6 +2,7 @@ package main
 
 // #cgo LDFLAGS: -lreadline
 // #include <stdlib.h>
+// #include <unistd.h>
 // #include <readline/readline.h>
 //
 // void
@@ -11,16 +12,39 @@ package main
 //     rl_bind_key('\t', rl_insert);
 // }
 //
+// void
+// init_rl_overstrike()
+// {
+//     /* insert carriage returns and backspaces, do not accept or edit the line.
+//        In a terminal, Enter sends a carriage return, so they are typed with C-v */
+//     if (!isatty(STDIN_FILENO)) {
+//         rl_bind_key('\r', rl_insert);
+//         rl_bind_key('\b', rl_insert);
+//     }
+// }
+//
 import "C"
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"unicode"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/kr/text"
@@ -29,12 +53,99 @@ import (
 var (
 	length     = flag.Int("l", 120, "maximum length of an output line")
 	tabstop    = flag.Int("t", 4, "number of spaces of a tab")
+	indentUnit = flag.Int("indent-unit", 0, "number of spaces of each level of indentation in the output, if different from -t")
 	join       = flag.Bool("j", false, "join short lines when wrapping text")
+	fitMin     = flag.Bool("fit-min", false, "wrap to the narrowest line of the input that is not the last of a paragraph, if less than -l")
+	maxJoin    = flag.Int("maxjoin", 0, "maximum number of lines joined into one, 0 for no limit")
+	rewrap     = flag.Bool("rewrap", false, "join the lines of each paragraph and wrap them again")
+	hardBreak  = flag.String("hardbreak", "", "do not join lines ending with this, like <br>, with the next line")
 	appendFile = flag.Bool("a", false, "append to file instead of overwriting")
+	noClobber  = flag.Bool("no-clobber", false, "do not overwrite an existing file, unless -force is set")
+	force      = flag.Bool("force", false, "overwrite an existing file, even with -no-clobber")
+	editOutput = flag.Bool("edit", false, "edit the formatted text with $EDITOR before writing it")
+	clip       = flag.Bool("clip", false, "copy the output to the clipboard, instead of stdout")
+	recursive  = flag.String("r", "", "format in place the files under this directory, instead of reading input")
+	glob       = flag.String("glob", "", "with -r, format only the files whose name matches this pattern, like *.txt")
+	gitMessage = flag.Bool("git", false, "format a git commit message, with the subject wrapped to 50 and the body to 72")
+	stream     = flag.Bool("stream", false, "output each paragraph as soon as it ends, not with flags that need all the input or output")
+	verbose    = flag.Bool("v", false, "with -r, report what happened to each file")
+
+	finalNewline = flag.String("final-newline", "", "end the output with exactly one newline (ensure) or none (strip)")
+	eolStyle     = flag.String("eol", "unix", "end output lines with \\n (unix), \\r\\n (dos) or \\r (mac)")
+	compact      = flag.Bool("compact", false, "output with minimal white space, a canonical form good for diffs")
+	lineTemplate = flag.String("line-template", "", "prefix output lines with this, formatted with the line number and the indent, like \"%3d| \"")
+	showIndent   = flag.Bool("show-indent", false, "show the indentation of output lines with · for spaces and → for tabs")
+	fixed        = flag.Bool("fixed", false, "pad or truncate output lines to exactly -l characters")
+
+	widthComment      = flag.Bool("width-comment", false, "set -l from a comment like // ted-width: N or # ted-width: N at the start of input")
+	stripWidthComment = flag.Bool("strip-width-comment", false, "with -width-comment, do not output the comment")
+	contextIndent     = flag.Bool("context-indent", false, "format the text without the indentation common to all lines and then indent it again")
+
+	tableLength   = flag.Int("table-length", 0, "maximum length of a table line, warn if exceeded")
+	tableInfo     = flag.Bool("table-info", false, "print to stderr the rows, columns and column widths and types of each table, in JSON")
+	tableComments = flag.String("table-comments", "", "align the comments starting with this, like #, at the end of table rows in a column of their own")
+	justifyTotal  = flag.Bool("justify-total", false, "shift the cells of the last row of tables to the right, if it has fewer cells, like totals")
+	overflowCSV   = flag.Bool("overflow-csv", false, "output tables wider than -table-length, or -l, as comma separated values")
+	tableTabstop  = flag.Int("render-tabs-as-spaces", 0, "number of spaces of a tab in tables, if different from -t")
+	elasticStrict = flag.Bool("elastic-strict", false, "align tables with the elastic tabstops algorithm, where empty cells break the columns, instead of text/tabwriter")
+	overstrike    = flag.String("overstrike", "", "output as is (keep) or print (resolve) lines overstruck with \\r or \\b")
+	escapes       = flag.Bool("expand-escapes", false, "interpret \\n, \\t and \\\\ in input lines")
+	preserveArt   = flag.Bool("preserve-art", false, "output as is lines that look like drawings, e.g boxes")
+	verse         = flag.Bool("verse", false, "output as is blocks of short lines, like poems, even with -j")
+	keepLines     = flag.String("keep-lines", "", "output as is the input lines with these numbers, like 3,5-7")
+	nowrapBelow   = flag.Int("nowrap-below", 0, "output as is the paragraphs whose lines are all shorter than this")
+	verseRatio    = flag.Float64("verse-ratio", 0.5, "with -verse, lines shorter than this fraction of -l are short")
+	rawVerbatim   = flag.Bool("raw-verbatim", false, "output the lines that are output as is exactly as read, without normalizing their indentation")
+	smartDetect   = flag.Bool("smart-detect", false, "guess which blocks are code and output them as is")
+
+	whitespaceReport = flag.Bool("whitespace-report", false, "print to stderr statistics about the use of tabs and spaces")
+	count            = flag.Bool("count", false, "print to stderr how many lines were joined and wrapped and how many tables were aligned")
+	levelPrefix      = flag.Bool("level-prefix", false, "prefix each paragraph with its indentation level, like [L1]")
+	reportOverflow   = flag.Bool("report-overflow", false, "report to stderr the words that make lines longer than -l")
+	preserveAligned  = flag.Bool("preserve-aligned", false, "output as is adjacent lines with words aligned after two or more spaces, like comments")
+	warnMixed        = flag.Bool("warn-mixed", false, "warn about lines indented with both tabs and spaces")
+	markParagraphs   = flag.String("mark-paragraphs", "", "put a line with this marker between paragraphs, so that the output can be split again")
+	unmark           = flag.String("unmark", "", "remove the lines with this marker, like the ones of -mark-paragraphs, from the input")
+	smartJoinSpace   = flag.Bool("smart-join-space", false, "when joining lines, put two spaces after sentences and none after hyphens")
+
+	nobreakAfter  = flag.String("nobreak-after", "", "do not break lines after single character words from this set")
+	nobreakBefore = flag.String("nobreak-before", "", "do not break lines before words starting with a character from this set")
+	typography    = flag.String("typography", "", "space punctuation by the rules of a language: fr, en or de")
+	noIndentWrap  = flag.Bool("no-indent-wrap", false, "wrap the text of indented lines to -l, not counting the indentation")
+	prefix        = flag.String("prefix", "", "format only the lines starting with this prefix, like // or #, and output the rest as is")
+	keepDangling  = flag.Bool("keep-dangling", false, "keep the slash at the end of lines with no line to join, like the last one")
+	maxWord       = flag.Int("maxword", 0, "break with a hyphen the words longer than this, 0 for no limit")
+	semantic      = flag.Bool("semantic", false, "put each sentence on a line of its own, instead of filling lines")
+	keepQuotes    = flag.Bool("keep-quotes", false, "do not break lines inside quoted strings, \"...\" or '...', shorter than -l")
+	md            = flag.Bool("md", false, "format markdown: reflow paragraphs and list items and output code blocks, headings and rules as is")
+	html          = flag.Bool("html", false, "do not break lines inside HTML tags and output <pre> and <code> blocks as is")
+	math          = flag.Bool("math", false, "do not break lines inside $...$ and output $$...$$ blocks as is")
+	dashSpacing   = flag.String("dash-spacing", "", "remove (tight) or put (loose) spaces around em dashes")
+	filterCmd     = flag.String("filter", "", "shell command to filter the text of each paragraph before wrapping")
+	lists         = flag.Bool("lists", false, "indent the wrapped lines of list items, like - or 1., under the text of the item")
+	outline       = flag.Bool("outline", false, "indent only the first line of indented paragraphs, as outline levels")
+	outlineIndent = flag.Int("outline-indent", 0, "with -outline, the indentation of the rest of the lines")
+	attribution   = flag.Bool("attribution", false, "align right lines starting with -- or — that follow text and end its block, like the author of a quote")
+	footnotes     = flag.Bool("footnotes", false, "wrap footnote definitions, like [1] text, with the text indented under the marker")
+
+	wordFreq      = flag.Bool("wordfreq", false, "output a table of the words of the input and their frequency")
+	stopwordsFile = flag.String("stopwords", "", "with -wordfreq, ignore the words listed in this file")
+
+	reverseLines = flag.Bool("reverse", false, "output lines in reverse order")
+	reverseRows  = flag.Bool("reverse-rows", false, "with -reverse, reverse the rows of tables too")
+
+	header          = flag.Bool("header", false, "the first row of each table is a header, with -export json the keys of objects")
+	export          = flag.String("export", "", "output only the tables, as tsv, csv or json")
+	underlineHeader = flag.Bool("underline-header", false, "underline the header of each table with dashes, implies -header")
+	tableBlank      = flag.String("table-blank", "break", "blank lines between table rows start a new table (break) or not (keep)")
+	emptyCells      = flag.String("empty-cells", "keep", "keep empty table cells as blank columns (keep) or drop them (collapse)")
+	leader          = flag.String("leader", "", "fill the space before the last column of tables with this character, like . in a table of contents")
+	kvAlign         = flag.Bool("kv-align", false, "tables are key<tab>value pairs, align the values")
+	kvEquals        = flag.Bool("kv-equals", false, "like -kv-align, but put = between keys and values")
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, `usage: ted [-l N] [-t N] [-j] [-a] [file]
+	fmt.Fprintf(os.Stderr, `usage: ted [flags] [file] [-- text...]
 
 Ted is a line-oriented text editor.
 
@@ -49,10 +160,49 @@ are formatted with margins both at the left and right ends.
 
 Lines that contain tabular data, i.e data separated with tabs are formatted
 using elastic tabstops http://nickgravgaard.com/elastictabstops/index.html.
+With -elastic-strict, an empty cell breaks its column, so cells align only with
+the cells right above and below them, unlike text/tabwriter.
+
+With -md, ted formats markdown. It joins and wraps the lines of paragraphs and
+list items, and outputs as is code blocks, headings and rules. The URLs
+of link reference definitions are not broken, only their long titles are.
+
+With -export, ted outputs only the tables, as tsv, csv or json, to extract them
+from the text.
+
+With -overstrike, carriage returns and backspaces are part of the input text,
+like in the output of old man(1), and are not used for editing lines. When typing
+in a terminal, enter them with C-v, since Enter still accepts the line.
+
+The arguments after --, if any, are the input text, one line per argument, and
+ted does not read any input.
+
+With -mark-paragraphs, ted puts a line with the marker between paragraphs, so
+that tools can split the output again into its paragraphs. With -unmark, ted
+removes these lines from the input.
 
 Ted writes the output to file, if specified, otherwise to stdout. Currently ted
 does not support editing of existing files and by default it overwrites the file.
-Use -a if you want to append output to an existing file.
+Use -a if you want to append output to an existing file. With -edit, ted opens
+the formatted text in $EDITOR, on /dev/tty, for the final touches and then formats
+it again. If the editor fails, the output is not edited.
+With -clip, ted copies the output to the clipboard, using xclip(1), pbcopy(1) or
+clip.exe, and writes it to file, if specified, but not to stdout.
+
+With -r, ted does not read any input. Instead it walks the directory and formats
+in place each text file whose name matches -glob, which is required. Directories
+whose name starts with a dot, like .git, are skipped. This is the only case where
+ted edits existing files, so -reverse, -wordfreq and -export, whose output is not
+the formatted text, cannot be used with it.
+
+With -reverse, ted outputs the lines in reverse order, which is handy for logs.
+Tables are moved as a whole and keep their rows in order, unless -reverse-rows
+is also set.
+
+With -smart-detect, ted guesses which blocks of lines, separated by blank lines,
+are code and outputs them as is, while the rest are formatted as usual. Code
+is recognized by the density of symbols, the line endings, the indentation and
+the raggedness of lines.
 
 Flags:
 `)
@@ -64,165 +214,2051 @@ func main() {
 	log.SetFlags(0)
 	log.SetPrefix("ted: ")
 	flag.Usage = usage
-	flag.Parse()
+
+	args, literal := splitLiteral(os.Args[1:])
+	flag.CommandLine.Parse(args)
 
 	if flag.NArg() > 1 {
 		usage()
 	}
 
-	C.init_rl()
+	switch *typography {
+	case "", "fr", "en", "de":
+	default:
+		log.Fatalf("invalid -typography %q: must be fr, en or de", *typography)
+	}
 
-	var buf bytes.Buffer
-	format(readlines(), &buf)
+	switch *dashSpacing {
+	case "", "tight", "loose":
+	default:
+		log.Fatalf("invalid -dash-spacing %q: must be tight or loose", *dashSpacing)
+	}
+
+	switch *tableBlank {
+	case "keep", "break":
+	default:
+		log.Fatalf("invalid -table-blank %q: must be keep or break", *tableBlank)
+	}
+
+	switch *emptyCells {
+	case "keep", "collapse":
+	default:
+		log.Fatalf("invalid -empty-cells %q: must be keep or collapse", *emptyCells)
+	}
+
+	switch *export {
+	case "", "tsv", "csv", "json":
+	default:
+		log.Fatalf("invalid -export %q: must be tsv, csv or json", *export)
+	}
+
+	switch *overstrike {
+	case "", "keep", "resolve":
+	default:
+		log.Fatalf("invalid -overstrike %q: must be keep or resolve", *overstrike)
+	}
 
-	w := os.Stdout
+	if _, ok := lineEndings[*eolStyle]; !ok {
+		log.Fatalf("invalid -eol %q: must be unix, dos or mac", *eolStyle)
+	}
+
+	switch *finalNewline {
+	case "", "ensure", "strip":
+	default:
+		log.Fatalf("invalid -final-newline %q: must be ensure or strip", *finalNewline)
+	}
+
+	if utf8.RuneCountInString(*leader) > 1 {
+		log.Fatalf("invalid -leader %q: must be one character", *leader)
+	}
+
+	if *maxWord < 0 || *maxWord == 1 {
+		// the parts of broken words end with a hyphen
+		log.Fatalf("invalid -maxword %d: must be at least 2, or 0 for no limit", *maxWord)
+	}
+
+	if *outlineIndent < 0 {
+		log.Fatalf("invalid -outline-indent %d: must not be negative", *outlineIndent)
+	}
+
+	if *stream {
+		if err := checkStream(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *recursive != "" {
+		if err := checkRecursive(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// check before reading the input, so that it is not lost
 	if flag.NArg() == 1 {
-		perms := os.O_WRONLY | os.O_CREATE
-		if *appendFile {
-			perms |= os.O_APPEND
-		} else {
-			perms |= os.O_TRUNC
+		if err := checkClobber(flag.Arg(0)); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		if fout, err := os.OpenFile(flag.Arg(0), perms, 0666); err == nil {
-			w = fout
-			defer fout.Close()
-		} else {
+	if *stopwordsFile != "" {
+		data, err := ioutil.ReadFile(*stopwordsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, word := range strings.Fields(string(data)) {
+			stopwords[strings.ToLower(word)] = true
+		}
+	}
+
+	if *keepLines != "" {
+		if err := parseLineList(*keepLines, keptLines); err != nil {
+			log.Fatalf("invalid -keep-lines %q: %v", *keepLines, err)
+		}
+	}
+
+	if *lineTemplate != "" {
+		if strings.Contains(formatGutter(*lineTemplate, 1, 0), "%!") {
+			log.Fatalf("invalid -line-template %q: may use the line number and the indent, like %%3d or %%[2]d", *lineTemplate)
+		}
+		// wrap the text in the space left by the gutter, which formatNumbered
+		// narrows further if the gutter of the last line is wider
+		*length -= gutterWidth(1)
+	}
+
+	if *recursive != "" {
+		if flag.NArg() > 0 || literal != nil {
+			usage()
+		}
+		if err := formatTree(*recursive, *glob); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	next := readline
+	if literal != nil {
+		next = scanLines(strings.NewReader(strings.Join(literal, "\n") + "\n"))
+	} else {
+		C.init_rl()
+		if *overstrike != "" {
+			C.init_rl_overstrike()
+		}
+	}
+
+	if *stream {
+		w := openOutput()
+		defer w.Close()
+
+		if err := formatStream(next, w); err != nil {
+			log.Fatal(err)
+		}
+		if *count {
+			changes.report()
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	formatText(readlines(next), &buf)
+
+	if *editOutput {
+		if err := editFormatted(&buf); err != nil {
+			log.Printf("%v: output is not edited", err)
+		}
+	}
+
+	finish(&buf)
+
+	if *clip {
+		if err := copyToClipboard(buf.Bytes()); err != nil {
 			log.Fatal(err)
 		}
+		if flag.NArg() == 0 {
+			return
+		}
 	}
 
+	w := openOutput()
+	defer w.Close()
+
 	if _, err := buf.WriteTo(w); err != nil {
 		log.Fatal(err)
 	}
+	if *count {
+		changes.report()
+	}
 }
 
-type line struct {
-	text       string // text of the line
-	indent     int    // number of spaces at the beginning of line
-	indented   bool   // indent > 0
-	incomplete bool   // line ended with \ (stripped from line.text)
-	blank      bool   // line is empty or contains only white space
-	tabular    bool   // has at least 2 columns separated by tabs
-	quoted     bool   // is indented only with tabs
+// changeCounts counts what ted changed in the input, for -count
+type changeCounts struct {
+	joined  int // input lines joined to the previous ones
+	wrapped int // lines wrapped into more than one output line
+	tables  int // tables aligned
 }
 
-func (l *line) concat(r *line) {
-	var builder strings.Builder
-	builder.Grow(len(l.text) + 1 + len(r.text))
-	builder.WriteString(l.text)
-	builder.WriteRune(' ')
-	builder.WriteString(r.text)
-	l.text = builder.String()
-	l.incomplete = r.incomplete
-	l.blank = l.blank && r.blank
+var changes changeCounts
 
-	becomesTabular := l.tabular || r.tabular || r.quoted
-	l.tabular = becomesTabular
-	l.quoted = l.quoted && !becomesTabular
+// report prints the counts to stderr
+func (c *changeCounts) report() {
+	log.Printf("%d lines joined, %d lines wrapped, %d tables aligned", c.joined, c.wrapped, c.tables)
 }
 
-// readline reads a line using readline(3). Returns the line and true on EOF
-func readline() (*line, bool) {
-	cstr := C.readline(nil)
-	defer C.free(unsafe.Pointer(cstr))
+// splitLiteral splits args at the first --, into the flags and the file before
+// it and the input text after it, one line per argument. The text is nil if
+// there is no --
+func splitLiteral(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
 
-	if cstr == nil {
-		return nil, true
+// checkClobber returns an error if the output file exists and -no-clobber
+// prohibits overwriting it
+func checkClobber(file string) error {
+	if !*noClobber || *force || *appendFile {
+		return nil
+	}
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s: file exists, use -force to overwrite it", file)
 	}
+	return nil
+}
 
-	text := C.GoString(cstr)
-	incomplete := strings.HasSuffix(text, "\\")
-	if incomplete {
-		text = text[0 : len(text)-1] // strip final slash
+// openOutput opens the file for the output, if specified, otherwise returns stdout
+func openOutput() *os.File {
+	if flag.NArg() == 0 {
+		return os.Stdout
 	}
 
-	indent, indentChars, lastTab, tabCount := 0, 0, 0, 0
-	inIndent := true
-	for i, r := range text {
-		if r == '\t' {
-			tabCount++
-			lastTab = i
-			if inIndent {
-				indent += *tabstop
-				indent -= indent % *tabstop
-				indentChars++
-			}
-		} else if unicode.IsSpace(r) {
-			if inIndent {
-				indent++
-				indentChars++
+	perms := os.O_WRONLY | os.O_CREATE
+	if *appendFile {
+		perms |= os.O_APPEND
+	} else {
+		perms |= os.O_TRUNC
+	}
+
+	fout, err := os.OpenFile(flag.Arg(0), perms, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fout
+}
+
+// unstreamable are the flags that need all the input, or all the output,
+// so they cannot be used with -stream
+var unstreamable = []string{
+	"reverse", "wordfreq", "export", "edit", "clip", "fit-min", "width-comment",
+	"context-indent", "git", "whitespace-report", "md", "html", "math",
+}
+
+// checkStream returns an error if a flag that cannot be used with -stream is set
+func checkStream() error {
+	for _, name := range unstreamable {
+		if f := flag.Lookup(name); f.Value.String() != f.DefValue {
+			return fmt.Errorf("-%s cannot be used with -stream", name)
+		}
+	}
+	if *tableBlank == "keep" {
+		// tables end at blank lines
+		return errors.New("-table-blank keep cannot be used with -stream")
+	}
+	return nil
+}
+
+// formatStream reads the input with next and fmts each paragraph, or table,
+// and writes it to w as soon as it ends, i.e at a blank line or at the end
+// of a table, instead of waiting for all the input. The parts of finish that
+// work line by line are applied to each output line
+func formatStream(next func() (string, bool), w io.Writer) error {
+	defer func() { lineOffset = 0 }()
+
+	// blank lines that -compact or -final-newline may drop are held
+	// back until the next line of text
+	written, blanks := 0, 0
+	writeLine := func(buf *bytes.Buffer, l string) {
+		if *fixed {
+			l = strings.TrimSuffix(pad(l+"\n", *length), "\n")
+		}
+		if *showIndent {
+			l = showIndentation(l)
+		}
+		if *lineTemplate != "" {
+			l = formatGutter(*lineTemplate, written+1, indentation(l)) + l
+		}
+		if *finalNewline == "strip" {
+			if written > 0 {
+				buf.WriteByte('\n')
 			}
+			buf.WriteString(l)
 		} else {
-			inIndent = false
+			buf.WriteString(l + "\n")
 		}
+		written++
 	}
 
-	blank := inIndent
-	quoted := !blank && tabCount > 0 && lastTab+1 == tabCount // all tabs at start of line
-	tabular := !blank && tabCount > 0 && !quoted
+	var texts []string
+	flush := func() error {
+		if len(texts) == 0 {
+			return nil
+		}
 
-	return &line{
-		text:       text[indentChars:], // strip indentation
-		indent:     indent,
-		indented:   indent > 0,
-		incomplete: incomplete,
-		blank:      blank,
-		tabular:    tabular,
-		quoted:     quoted,
-	}, false
+		var formatted bytes.Buffer
+		formatText(readlines(scanLines(strings.NewReader(strings.Join(texts, "\n")+"\n"))), &formatted)
+		lineOffset += len(texts)
+		texts = texts[:0]
+		if formatted.Len() == 0 {
+			return nil
+		}
+
+		var buf bytes.Buffer
+		for _, l := range strings.Split(strings.TrimSuffix(formatted.String(), "\n"), "\n") {
+			if *compact {
+				l = strings.TrimRight(l, " \t")
+			}
+			if l == "" && (*compact || *finalNewline != "") {
+				blanks++
+				continue
+			}
+			if *compact && blanks > 0 {
+				// squeezed into one, none at the start
+				blanks = 1
+				if written == 0 {
+					blanks = 0
+				}
+			}
+			for ; blanks > 0; blanks-- {
+				writeLine(&buf, "")
+			}
+			writeLine(&buf, l)
+		}
+		convertEOL(&buf)
+		_, err := buf.WriteTo(w)
+		return err
+	}
+
+	inTable := false
+	for text, eof := next(); !eof; text, eof = next() {
+		l := newLine(text, false)
+		if inTable && !l.tabular {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		inTable = l.tabular
+
+		texts = append(texts, text)
+		if l.blank {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
 }
 
-// readlines reads all the input and concatenates lines where needed
-func readlines() []*line {
-	lines := make([]*line, 0, 32)
+// notInPlace are the flags whose output is not the formatted text, so they
+// cannot be used with -r, which writes the output over the files
+var notInPlace = []string{"reverse", "wordfreq", "export"}
 
-	var prevLine *line
-	for currLine, eof := readline(); !eof; currLine, eof = readline() {
-		if prevLine != nil && !currLine.blank && (prevLine.incomplete || *join) {
-			prevLine.concat(currLine)
-		} else {
-			lines = append(lines, currLine)
-			prevLine = currLine
+// checkRecursive returns an error if a flag that cannot be used with -r is set
+func checkRecursive() error {
+	for _, name := range notInPlace {
+		if f := flag.Lookup(name); f.Value.String() != f.DefValue {
+			return fmt.Errorf("-%s cannot be used with -r", name)
 		}
 	}
+	return nil
+}
+
+// formatTree formats in place the files under dir whose name matches pattern.
+// Hidden directories are skipped
+func formatTree(dir, pattern string) error {
+	// files are edited in place, so they are chosen explicitly
+	if pattern == "" {
+		return errors.New("-r needs -glob")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("-glob %q: %v", pattern, err)
+	}
 
-	return lines
+	failed := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+			// like .git
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, info.Name()); !ok {
+			return nil
+		}
+
+		if err := formatFile(path, info.Mode()); err != nil {
+			log.Print(err)
+			failed = true
+		}
+		return nil
+	})
+
+	if err == nil && failed {
+		err = errors.New("some files were not formatted")
+	}
+	return err
 }
 
-var (
-	spaces = strings.Repeat(" ", 256)
-	ats    = strings.Repeat("@", 256)
-)
+// formatFile formats the file in place. Binary files are skipped
+func formatFile(path string, mode os.FileMode) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
 
-// format fmts all the inputs lines and outputs to the buffer
-func format(lines []*line, buf *bytes.Buffer) {
-	tabw := tabwriter.NewWriter(buf, *tabstop, *tabstop, 1, ' ', 0)
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		if *verbose {
+			log.Printf("%s: skipped, binary file", path)
+		}
+		return nil
+	}
 
-	for _, line := range lines {
-		if line.tabular {
-			tabw.Write([]byte(line.text + "\n"))
-		} else {
-			tabw.Flush()
+	var buf bytes.Buffer
+	formatText(readlines(scanLines(bytes.NewReader(data))), &buf)
+	finish(&buf)
 
-			switch {
-			case line.blank:
-				// ignore
-			case line.quoted:
-				t := text.Indent(text.Wrap(line.text, *length-*tabstop*2), spaces[0:*tabstop])
-				buf.WriteString(t)
-			case line.indented:
-				// add a prefix of @s as placeholder for the indentation, wrap and then remove it
-				t := text.Wrap(ats[0:line.indent]+line.text, *length)
-				buf.WriteString(spaces[0:line.indent])
-				buf.WriteString(t[line.indent:])
-			default:
-				t := text.Wrap(line.text, *length)
-				buf.WriteString(t)
-			}
-			buf.WriteRune('\n')
+	if bytes.Equal(data, buf.Bytes()) {
+		if *verbose {
+			log.Printf("%s: unchanged", path)
+		}
+		return nil
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), mode); err != nil {
+		return err
+	}
+	if *verbose {
+		log.Printf("%s: formatted", path)
+	}
+	return nil
+}
+
+// formatText fmts the lines, in the order set by the flags, and outputs to the buffer
+func formatText(lines []*line, buf *bytes.Buffer) {
+	if *wordFreq {
+		lines = wordFrequencies(lines)
+	}
+	if *reverseLines {
+		lines = reverse(lines, *reverseRows, *header || *underlineHeader)
+	}
+	if *export != "" {
+		exportTables(lines, *export, *header, buf)
+		return
+	}
+	formatNumbered(lines, buf)
+}
+
+// formatNumbered fmts the lines like formatLines, in the space left by the
+// gutter of -line-template. The gutter may grow with the line number, like
+// %d, or the indent, so the lines are formatted again, narrower, until the
+// widest gutter fits. With -stream the rest of the lines are not known and
+// only the gutter of the first line is left
+func formatNumbered(lines []*line, buf *bytes.Buffer) {
+	if *lineTemplate == "" || *stream {
+		formatLines(lines, buf)
+		return
+	}
+
+	defer func(l int) { *length = l }(*length)
+	counts := changes
+	gutter := gutterWidth(1) // already left by main
+	for {
+		// formatting changes the lines, like -context-indent, so format copies
+		copies := make([]*line, len(lines))
+		for i, l := range lines {
+			c := *l
+			copies[i] = &c
+		}
+
+		var numbered bytes.Buffer
+		formatLines(copies, &numbered)
+		last := widestGutter(numbered.String())
+		if last <= gutter {
+			buf.Write(numbered.Bytes())
+			return
+		}
+		*length -= last - gutter
+		gutter = last
+		changes = counts
+	}
+}
+
+// gutterWidth returns the width of the gutter of -line-template for line n
+// without indent
+func gutterWidth(n int) int {
+	return utf8.RuneCountInString(formatGutter(*lineTemplate, n, 0))
+}
+
+// widestGutter returns the width of the widest gutter of -line-template
+// for the lines of s
+func widestGutter(s string) int {
+	widest := 0
+	for i, l := range strings.SplitAfter(s, "\n") {
+		if l == "" {
+			continue
+		}
+		if w := utf8.RuneCountInString(formatGutter(*lineTemplate, i+1, indentation(l))); w > widest {
+			widest = w
 		}
 	}
+	return widest
+}
+
+// formatGutter formats tmpl with the line number n and the indent of the
+// line, in this order. A template may use both, like "%3d:%d ", only the
+// line number, like "%3d| ", only the indent, like "%[2]d ", or none of
+// them, like "> "
+func formatGutter(tmpl string, n, indent int) string {
+	args := []interface{}{n, indent}
+	for {
+		// the arguments that tmpl does not use are reported as EXTRA
+		s := fmt.Sprintf(tmpl, args...)
+		if len(args) == 0 || !strings.Contains(s, "%!(EXTRA") {
+			return s
+		}
+		args = args[:len(args)-1]
+	}
+}
+
+// indentation returns the number of spaces and tabs at the start of l,
+// including those shown by -show-indent
+func indentation(l string) int {
+	n := 0
+	for _, r := range l {
+		if r != ' ' && r != '\t' && r != '·' && r != '→' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// formatLines fmts the lines as a commit message, with -git, or as text
+// without its common indentation, with -context-indent, or as plain text
+func formatLines(lines []*line, buf *bytes.Buffer) {
+	if lineOffset == 0 {
+		// new input, not the next paragraph of -stream
+		marks = paragraphMarks{}
+	}
+
+	if *gitMessage {
+		formatCommitMessage(lines, buf)
+		return
+	}
+
+	if common := dedent(lines); *contextIndent && common > 0 {
+		defer func(l int) { *length = l }(*length)
+		*length -= common
 
-	tabw.Flush()
+		markRaw = true
+		defer func() { markRaw = false }()
+
+		var dedented bytes.Buffer
+		format(lines, &dedented)
+		buf.WriteString(reindent(dedented.String(), spaces[0:common]))
+		return
+	}
+	format(lines, buf)
+}
+
+// editFormatted launches $EDITOR on the formatted text in buf and fmts
+// the edited text again, in the same mode. The flags that transform the
+// input, like -reverse, are not applied again and exported tables are not
+// formatted at all
+func editFormatted(buf *bytes.Buffer) error {
+	edited, err := edit(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	buf.Reset()
+	if *export != "" {
+		buf.Write(edited)
+		return nil
+	}
+	// -count reports the changes to the input, not to the edited text
+	defer func(c changeCounts) { changes = c }(changes)
+	formatNumbered(readlines(scanLines(bytes.NewReader(edited))), buf)
+	return nil
+}
+
+// rawMark marks the raw lines of -raw-verbatim in the output of format when
+// markRaw is set, so that -context-indent does not indent them twice
+const rawMark = "\x02"
+
+// markRaw is set while -context-indent formats the text without its common indentation
+var markRaw bool
+
+// reindent indents the non-empty lines of s with indent, like text.Indent,
+// except the lines marked with rawMark, which are output as read, without the mark
+func reindent(s, indent string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if strings.Contains(l, rawMark) {
+			lines[i] = strings.Replace(l, rawMark, "", 1)
+		} else if l != "" && l != "\n" {
+			lines[i] = indent + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// formatCommitMessage fmts lines as a git commit message. The subject, the
+// first line, is wrapped to 50 characters and the part that does not fit
+// starts the body. The body is separated by a blank line and wrapped to 72
+func formatCommitMessage(lines []*line, buf *bytes.Buffer) {
+	for len(lines) > 0 && lines[0].blank {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	subject := strings.SplitN(wrap(lines[0].text, 50), "\n", 2)
+	buf.WriteString(subject[0] + "\n")
+
+	body := lines[1:]
+	for len(body) > 0 && body[0].blank {
+		body = body[1:]
+	}
+	if len(subject) > 1 {
+		rest := newLine(strings.ReplaceAll(subject[1], "\n", " "), false)
+		body = append([]*line{rest, newLine("", false)}, body...)
+	}
+	if len(body) == 0 {
+		return
+	}
+	buf.WriteByte('\n')
+
+	defer func(l int) { *length = l }(*length)
+	*length = 72
+	format(body, buf)
+}
+
+// dedent removes the indentation common to all lines, if -context-indent
+// is set, and returns it
+func dedent(lines []*line) int {
+	if !*contextIndent {
+		return 0
+	}
+
+	common := -1
+	for _, l := range lines {
+		if !l.blank && (common < 0 || l.indent < common) {
+			common = l.indent
+		}
+	}
+	if common <= 0 {
+		return 0
+	}
+
+	for _, l := range lines {
+		if !l.blank {
+			l.indent -= common
+			l.indented = l.indent > 0
+			l.quoted = l.quoted && l.indented
+		}
+	}
+	return common
+}
+
+// keptLines are the numbers of the input lines output as is by -keep-lines
+var keptLines = make(map[int]bool)
+
+// parseLineList adds to lines the line numbers of list, a comma separated
+// list of numbers, like 3, or ranges of numbers, like 5-7
+func parseLineList(list string, lines map[int]bool) error {
+	for _, item := range strings.Split(list, ",") {
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return err
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return err
+		}
+		if first < 1 || last < first {
+			return fmt.Errorf("bad range %s", item)
+		}
+		for n := first; n <= last; n++ {
+			lines[n] = true
+		}
+	}
+	return nil
+}
+
+// stopwords are the words ignored by -wordfreq
+var stopwords = make(map[string]bool)
+
+// wordFrequencies returns a table of the words of lines and the number of
+// times each one appears, most frequent first
+func wordFrequencies(lines []*line) []*line {
+	counts := make(map[string]int)
+	for _, l := range lines {
+		words := strings.FieldsFunc(l.text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+		})
+		for _, word := range words {
+			if word = strings.ToLower(strings.Trim(word, "'")); word != "" && !stopwords[word] {
+				counts[word]++
+			}
+		}
+	}
+
+	words := make([]string, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	table := make([]*line, len(words))
+	for i, word := range words {
+		table[i] = newLine(fmt.Sprintf("%s\t%d", word, counts[word]), false)
+	}
+	return table
+}
+
+// finish applies to the formatted text the flags that affect the whole of it
+func finish(buf *bytes.Buffer) {
+	if *compact {
+		compacted := compactText(buf.String())
+		buf.Reset()
+		buf.WriteString(compacted)
+	}
+
+	if *finalNewline != "" {
+		buf.Truncate(len(bytes.TrimRight(buf.Bytes(), "\n")))
+		if *finalNewline == "ensure" && buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+	}
+
+	if *fixed {
+		padded := pad(buf.String(), *length)
+		buf.Reset()
+		buf.WriteString(padded)
+	}
+
+	if *showIndent {
+		shown := showIndentation(buf.String())
+		buf.Reset()
+		buf.WriteString(shown)
+	}
+
+	if *lineTemplate != "" {
+		numbered := numberLines(buf.String(), *lineTemplate)
+		buf.Reset()
+		buf.WriteString(numbered)
+	}
+
+	convertEOL(buf)
+}
+
+// numberLines prefixes each line of s with tmpl formatted by formatGutter
+func numberLines(s, tmpl string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = formatGutter(tmpl, i+1, indentation(l)) + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// showIndentation replaces the spaces of the indentation of each line of s
+// with · and the tabs with →
+func showIndentation(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		indent := strings.NewReplacer(" ", "·", "\t", "→").Replace(l[0:n])
+		lines[i] = indent + l[n:]
+	}
+	return strings.Join(lines, "")
+}
+
+// lineEndings are the line endings of the -eol values
+var lineEndings = map[string]string{
+	"unix": "\n",
+	"dos":  "\r\n",
+	"mac":  "\r",
+}
+
+// convertEOL replaces the newlines of the text in buf with the line ending set by -eol
+func convertEOL(buf *bytes.Buffer) {
+	if eol := lineEndings[*eolStyle]; eol != "\n" {
+		converted := bytes.ReplaceAll(buf.Bytes(), []byte{'\n'}, []byte(eol))
+		buf.Reset()
+		buf.Write(converted)
+	}
+}
+
+type line struct {
+	num        int    // number of the line in the input
+	text       string // text of the line
+	indent     int    // number of spaces at the beginning of line
+	indented   bool   // indent > 0
+	incomplete bool   // line ended with \ (stripped from line.text)
+	blank      bool   // line is empty or contains only white space
+	tabular    bool   // has at least 2 columns separated by tabs
+	quoted     bool   // is indented only with tabs
+	verbatim   bool   // is output as is, without wrapping
+	tabbed     bool   // indentation has tabs
+	spaced     bool   // indentation has spaces
+	trailing   bool   // line ends with white space
+	footnote   int    // width of the [N] marker of a footnote definition, with the spaces after it
+	attributed bool   // is the attribution, like -- Author, of the previous lines
+	width      int    // line length set by a ted-width comment
+	prefix     string // indentation and -prefix stripped from the line
+	rawText    string // line as read, with its indentation
+}
+
+func (l *line) concat(r *line) {
+	sep := separator(l.text, r.text)
+
+	var builder strings.Builder
+	builder.Grow(len(l.text) + len(sep) + len(r.text))
+	builder.WriteString(l.text)
+	builder.WriteString(sep)
+	builder.WriteString(r.text)
+	l.text = builder.String()
+	l.incomplete = r.incomplete
+	l.blank = l.blank && r.blank
+
+	becomesTabular := l.tabular || r.tabular || r.quoted
+	l.tabular = becomesTabular
+	l.quoted = l.quoted && !becomesTabular
+}
+
+// separator returns the text to put between l and r when they are joined.
+// It is a space, unless -smart-join-space is set. Then it is two spaces
+// after the end of a sentence, nothing after a hyphenated word part and
+// nothing if any of l or r is empty
+func separator(l, r string) string {
+	if !*smartJoinSpace {
+		return " "
+	}
+
+	ltrim := strings.TrimRight(l, ")]'\"")
+	switch {
+	case l == "" || r == "":
+		return ""
+	case strings.HasSuffix(ltrim, ".") || strings.HasSuffix(ltrim, "!") || strings.HasSuffix(ltrim, "?"):
+		return "  "
+	case len(l) > 1 && strings.HasSuffix(l, "-"):
+		last, _ := utf8.DecodeLastRuneInString(l[:len(l)-1])
+		first, _ := utf8.DecodeRuneInString(r)
+		if unicode.IsLetter(last) && unicode.IsLetter(first) {
+			return ""
+		}
+	}
+	return " "
+}
+
+// readline reads a line using readline(3). Returns the line and true on EOF
+func readline() (string, bool) {
+	cstr := C.readline(nil)
+	defer C.free(unsafe.Pointer(cstr))
+
+	if cstr == nil {
+		return "", true
+	}
+
+	return C.GoString(cstr), false
+}
+
+// scanLines returns a function that reads lines from r like readline
+func scanLines(r io.Reader) func() (string, bool) {
+	scanner := bufio.NewScanner(r)
+	return func() (string, bool) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				log.Fatal(err)
+			}
+			return "", true
+		}
+		return scanner.Text(), false
+	}
+}
+
+// terminal is where the editor of -edit reads and writes, since the
+// input and the output of ted may be pipes
+var terminal = "/dev/tty"
+
+// edit writes text to a temporary file, launches $EDITOR on it
+// and returns the edited text
+func edit(text []byte) ([]byte, error) {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		return nil, errors.New("$EDITOR is not set")
+	}
+
+	f, err := ioutil.TempFile("", "ted")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tty, err := os.OpenFile(terminal, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v", editor[0], err)
+	}
+
+	return ioutil.ReadFile(f.Name())
+}
+
+// clipboardCommands are the commands, by OS, that copy their input to the clipboard
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip.exe"}},
+	"":        {{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}, {"wl-copy"}, {"clip.exe"}},
+}
+
+// copyToClipboard copies text to the clipboard with the first available clipboard command
+func copyToClipboard(text []byte) error {
+	commands, ok := clipboardCommands[runtime.GOOS]
+	if !ok {
+		commands = clipboardCommands[""]
+	}
+
+	for _, command := range commands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin, cmd.Stderr = bytes.NewReader(text), os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", command[0], err)
+		}
+		return nil
+	}
+
+	return errors.New("no clipboard command found")
+}
+
+// continued strips the final slash of text and reports whether it was there
+func continued(text string) (string, bool) {
+	if strings.HasSuffix(text, "\\") {
+		return text[0 : len(text)-1], true
+	}
+	return text, false
+}
+
+// expandEscapes interprets the escapes \n, \t and \\ of text and splits it
+// at the newlines. A final unescaped slash marks the last line as incomplete
+func expandEscapes(text string) ([]string, bool) {
+	var texts []string
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' {
+			builder.WriteByte(text[i])
+			continue
+		}
+		if i+1 == len(text) {
+			return append(texts, builder.String()), true
+		}
+
+		i++
+		switch text[i] {
+		case 'n':
+			texts = append(texts, builder.String())
+			builder.Reset()
+		case 't':
+			builder.WriteByte('\t')
+		case '\\':
+			builder.WriteByte('\\')
+		default:
+			builder.WriteByte('\\')
+			builder.WriteByte(text[i])
+		}
+	}
+
+	return append(texts, builder.String()), false
+}
+
+// withPrefix returns l without its indentation, prefix and the white space after
+// it, if its text starts with prefix, otherwise it marks l as verbatim, so that
+// only prefixed lines are formatted
+func withPrefix(l *line, prefix string) *line {
+	if !strings.HasPrefix(l.text, prefix) {
+		l.verbatim = true
+		return l
+	}
+
+	rest := strings.TrimPrefix(l.text, prefix)
+	text := strings.TrimLeft(rest, " \t")
+	stripped := newLine(text, l.incomplete)
+	stripped.prefix = spaces[0:l.indent] + prefix + rest[:len(rest)-len(text)]
+	return stripped
+}
+
+// resolveOverstrike returns the text that is printed by text with carriage
+// returns and backspaces, i.e when characters are printed over others
+func resolveOverstrike(text string) string {
+	var printed []rune
+	col := 0
+	for _, r := range text {
+		switch {
+		case r == '\r':
+			col = 0
+		case r == '\b':
+			if col > 0 {
+				col--
+			}
+		case col < len(printed):
+			if r != ' ' {
+				printed[col] = r
+			}
+			col++
+		default:
+			for len(printed) < col {
+				printed = append(printed, ' ')
+			}
+			printed = append(printed, r)
+			col++
+		}
+	}
+	return string(printed)
+}
+
+// newLine classifies the text of an input line
+func newLine(text string, incomplete bool) *line {
+	indent, indentChars, lastTab, tabCount := 0, 0, 0, 0
+	inIndent, tabbed, spaced := true, false, false
+	for i, r := range text {
+		if r == '\t' {
+			tabCount++
+			lastTab = i
+			if inIndent {
+				indent += *tabstop
+				indent -= indent % *tabstop
+				indentChars++
+				tabbed = true
+			}
+		} else if unicode.IsSpace(r) {
+			if inIndent {
+				indent++
+				indentChars++
+				spaced = true
+			}
+		} else {
+			inIndent = false
+		}
+	}
+
+	blank := inIndent
+	quoted := !blank && tabCount > 0 && lastTab+1 == tabCount // all tabs at start of line
+	tabular := !blank && tabCount > 0 && !quoted
+
+	return &line{
+		text:       text[indentChars:], // strip indentation
+		indent:     indent,
+		indented:   indent > 0,
+		incomplete: incomplete,
+		blank:      blank,
+		tabular:    tabular,
+		quoted:     quoted,
+		tabbed:     tabbed && !blank,
+		spaced:     spaced && !blank,
+		trailing:   strings.TrimRightFunc(text, unicode.IsSpace) != text,
+		footnote:   footnoteMarker(text[indentChars:]),
+	}
+}
+
+var footnoteDefinition = regexp.MustCompile(`^\[[0-9]+\]\s+`)
+
+var listMarker = regexp.MustCompile(`^([-*+•]|[0-9]+[.)]) +`)
+
+// listItem returns the marker of a list item, like "- " or "10. ", with the
+// spaces after it, if text is a list item and -lists is set, otherwise ""
+func listItem(text string) string {
+	if !*lists && !*md {
+		return ""
+	}
+	return listMarker.FindString(text)
+}
+
+// footnoteMarker returns the width of the [N] marker, and the spaces after it,
+// if text is a footnote definition and -footnotes is set, otherwise 0
+func footnoteMarker(text string) int {
+	if !*footnotes {
+		return 0
+	}
+	return len(footnoteDefinition.FindString(text))
+}
+
+// readlines reads all the input with next and concatenates lines where needed
+func readlines(next func() (string, bool)) []*line {
+	input := make([]*line, 0, 32)
+	num := lineOffset
+	for text, eof := next(); !eof; text, eof = next() {
+		num++
+		raw := text
+		if *unmark != "" && strings.TrimSpace(text) == *unmark {
+			continue
+		}
+		var texts []string
+		var incomplete bool
+		if *escapes {
+			texts, incomplete = expandEscapes(text)
+		} else {
+			text, incomplete = continued(text)
+			texts = []string{text}
+		}
+
+		for i, t := range texts {
+			overstruck := strings.ContainsAny(t, "\r\b")
+			if overstruck && *overstrike == "resolve" {
+				t = resolveOverstrike(t)
+			}
+
+			l := newLine(t, incomplete && i == len(texts)-1)
+			if *prefix != "" {
+				l = withPrefix(l, *prefix)
+			}
+			if *indentUnit > 0 {
+				// each level of indentation is a tabstop in the input and an indent unit in the output
+				l.indent = l.indent / *tabstop * *indentUnit + l.indent%*tabstop
+				growIndentation(l.indent + len(l.text))
+			}
+			l.num = num
+			l.rawText = raw
+			if *escapes {
+				l.rawText = t
+			}
+			l.verbatim = l.verbatim || *preserveArt && isArt(l.text) || overstruck && *overstrike == "keep" ||
+				*gitMessage && strings.HasPrefix(l.text, "#") || // git comments
+				keptLines[num]
+			input = append(input, l)
+		}
+	}
+
+	if *warnMixed {
+		for _, l := range input {
+			if l.tabbed && l.spaced {
+				log.Printf("line %d: indentation mixes tabs and spaces", l.num)
+			}
+		}
+	}
+
+	if *whitespaceReport {
+		reportWhitespace(input)
+	}
+
+	if *smartDetect {
+		detectCode(input)
+	}
+
+	if *verse {
+		detectVerse(input, int(*verseRatio*float64(*length)))
+	}
+
+	if *nowrapBelow > 0 {
+		keepShort(input, *nowrapBelow)
+	}
+
+	if *preserveAligned {
+		detectAligned(input)
+	}
+
+	if *widthComment {
+		findWidthComment(input)
+	}
+
+	if *math {
+		markDisplayMath(input)
+	}
+
+	if *html {
+		markHTMLBlocks(input)
+	}
+
+	if *md {
+		markMarkdown(input)
+	}
+
+	for _, l := range input {
+		if l.verbatim && l.incomplete {
+			// verbatim lines are not joined, so the slash is part of their text
+			l.text += "\\"
+			l.incomplete = false
+		}
+	}
+
+	if *fitMin {
+		fittedLength = narrowest(input)
+	}
+
+	lines := make([]*line, 0, len(input))
+
+	var prevLine, prevInput *line
+	joined := 0 // number of input lines in prevLine
+	for i, currLine := range input {
+		joinable := prevLine != nil && !prevLine.verbatim && !currLine.verbatim && (*maxJoin <= 0 || joined < *maxJoin)
+		if *hardBreak != "" && prevLine != nil && strings.HasSuffix(prevLine.text, *hardBreak) {
+			joinable = false
+		}
+		inFootnote := prevLine != nil && prevLine.footnote > 0 && currLine.indented && currLine.footnote == 0
+		inParagraph := (*rewrap || *md) && prevLine != nil && paragraph(prevLine, prevInput, currLine)
+		if *attribution && prevInput != nil && !prevInput.blank && (i == len(input)-1 || input[i+1].blank) {
+			// the attribution is the last line of the block, unlike a dash that starts a line of text
+			currLine.attributed = strings.HasPrefix(currLine.text, "—") || strings.HasPrefix(currLine.text, "--")
+			joinable = joinable && !currLine.attributed
+		}
+		if listItem(currLine.text) != "" || currLine.footnote > 0 || linkReference(currLine) != "" || prevLine != nil && linkReference(prevLine) != "" {
+			joinable = false
+		}
+		prevInput = currLine
+		if joinable && !currLine.blank && (prevLine.incomplete || *join || inFootnote || inParagraph) {
+			prevLine.concat(currLine)
+			joined++
+			changes.joined++
+		} else {
+			lines = append(lines, currLine)
+			prevLine = currLine
+			joined = 1
+		}
+	}
+
+	if *keepDangling {
+		// the slash of lines that are still incomplete had no line to join
+		for _, l := range lines {
+			if l.incomplete {
+				l.text += "\\"
+				l.blank = false
+				l.incomplete = false
+			}
+		}
+	}
+
+	return lines
+}
+
+// paragraph reports whether curr continues the paragraph of prev, where prevInput
+// is the input line before curr. Paragraphs are separated by blank lines, tables,
+// quotes and lines more indented than the previous ones
+func paragraph(prev, prevInput, curr *line) bool {
+	if prev.blank || prev.tabular || prev.quoted || curr.tabular || curr.quoted {
+		return false
+	}
+	if marker := listItem(prev.text); marker != "" {
+		// the lines of an item are indented under its text
+		return curr.indent == prev.indent+utf8.RuneCountInString(marker)
+	}
+	return curr.indent <= prevInput.indent
+}
+
+// isArt guesses whether text is part of a drawing. This is the case if it has
+// box drawing characters, if it starts and ends with | or + like a box, or
+// if at least half of its characters are drawing symbols like - | / \ _
+func isArt(text string) bool {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return false
+	}
+	if strings.ContainsAny(text[:1], "|+") && strings.ContainsAny(text[len(text)-1:], "|+") {
+		return true
+	}
+
+	art, all := 0, 0
+	for _, r := range text {
+		if r >= '\u2500' && r <= '\u259f' {
+			return true
+		}
+		if strings.ContainsRune("+-|/\\_=#*~^<>", r) {
+			art++
+		}
+		if !unicode.IsSpace(r) {
+			all++
+		}
+	}
+	return art >= 3 && art*2 >= all
+}
+
+var widthCommentPattern = regexp.MustCompile(`^(//|#)\s*ted-width:\s*([0-9]+)\s*$`)
+
+// findWidthComment looks for a comment like // ted-width: N or # ted-width: N
+// in the comment block at the start of lines. If found, the comment is marked
+// with the width and output as is
+func findWidthComment(lines []*line) {
+	for _, l := range lines {
+		if !l.blank && !strings.HasPrefix(l.text, "//") && !strings.HasPrefix(l.text, "#") {
+			return
+		}
+
+		if m := widthCommentPattern.FindStringSubmatch(strings.TrimSpace(l.text)); m != nil {
+			if n, err := strconv.Atoi(m[2]); err == nil && n > 0 {
+				l.width = n
+				l.verbatim = true
+				return
+			}
+		}
+	}
+}
+
+// fittedLength is the line length computed by -fit-min for the last input
+var fittedLength int
+
+// lineOffset is added to the numbers of the lines read by readlines, so that
+// with -stream the lines of each paragraph are numbered like in all the input
+var lineOffset int
+
+// paragraphMarks is the state of -mark-paragraphs, whether a paragraph was
+// written and whether blank lines followed it
+type paragraphMarks struct {
+	written, separated bool
+}
+
+// marks is kept across the calls of format, so that with -stream the
+// paragraphs are marked like in all the input
+var marks paragraphMarks
+
+// narrowest returns the length of the narrowest line that is followed by
+// another line of the same paragraph, i.e it is not short because it ends
+// the paragraph. It returns 0 if there is no such line
+func narrowest(lines []*line) int {
+	width := 0
+	for i := 0; i+1 < len(lines); i++ {
+		l, next := lines[i], lines[i+1]
+		if l.blank || l.tabular || l.verbatim || next.blank || next.tabular || next.verbatim {
+			continue
+		}
+
+		if w := l.indent + utf8.RuneCountInString(strings.TrimRightFunc(l.text, unicode.IsSpace)); width == 0 || w < width {
+			width = w
+		}
+	}
+	return width
+}
+
+// markDisplayMath marks as verbatim the lines of $$...$$ math blocks, from
+// a line starting with $$ up to the line that ends with $$
+func markDisplayMath(lines []*line) {
+	inMath := false
+	for _, l := range lines {
+		t := strings.TrimSpace(l.text)
+		if !inMath && strings.HasPrefix(t, "$$") {
+			inMath = true
+			t = t[2:]
+		}
+		if inMath {
+			l.verbatim = true
+			inMath = !strings.HasSuffix(t, "$$")
+		}
+	}
+}
+
+var htmlBlockStart = regexp.MustCompile(`^<(pre|code)[\s>]`)
+
+// markHTMLBlocks marks as verbatim the lines of <pre> and <code> blocks, from
+// a line starting with the tag up to the line with the closing tag
+func markHTMLBlocks(lines []*line) {
+	closing := ""
+	for _, l := range lines {
+		if closing == "" {
+			if m := htmlBlockStart.FindStringSubmatch(strings.ToLower(l.text)); m != nil {
+				closing = "</" + m[1] + ">"
+			}
+		}
+		if closing != "" {
+			l.verbatim = true
+			if strings.Contains(strings.ToLower(l.text), closing) {
+				closing = ""
+			}
+		}
+	}
+}
+
+var (
+	mdFence   = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	mdHeading = regexp.MustCompile(`^ {0,3}#{1,6}( |$)`)
+	mdRule    = regexp.MustCompile(`^ {0,3}(([-*_]) *){3,}$`)
+	mdSetext  = regexp.MustCompile(`^ {0,3}(=+|-+) *$`)
+	mdLinkRef = regexp.MustCompile(`^\[[^\]]+\]: *\S+`)
+)
+
+// linkReference returns the label and the URL of a markdown link reference
+// definition, like [id]: http://example.com "Title", if -md is set, otherwise ""
+func linkReference(l *line) string {
+	if !*md || l.indent > 3 {
+		return ""
+	}
+	return mdLinkRef.FindString(l.text)
+}
+
+// markMarkdown marks as verbatim the fenced code blocks, with their fences,
+// the indented code blocks, the headings and the rules of markdown. A fence is
+// closed by a fence of the same characters, at least as long, and an unclosed
+// one extends to the end. Indented code blocks start after a blank line
+func markMarkdown(lines []*line) {
+	fence, code := "", false
+	for i, l := range lines {
+		raw := spaces[0:l.indent] + l.text
+		if fence != "" {
+			l.verbatim = true
+			if m := mdFence.FindStringSubmatch(raw); m != nil && m[1][0] == fence[0] && len(m[1]) >= len(fence) &&
+				strings.TrimSpace(raw[len(m[0]):]) == "" {
+				fence = ""
+			}
+			continue
+		}
+		if l.blank {
+			continue
+		}
+
+		code = l.indent >= 4 && (i == 0 || lines[i-1].blank || code)
+		switch {
+		case code:
+			l.verbatim = true
+		case mdFence.MatchString(raw):
+			fence = mdFence.FindStringSubmatch(raw)[1]
+			l.verbatim = true
+		case mdHeading.MatchString(raw), mdRule.MatchString(raw):
+			l.verbatim = true
+		case i > 0 && !lines[i-1].blank && mdSetext.MatchString(raw):
+			// the underline of a heading and the heading
+			l.verbatim = true
+			lines[i-1].verbatim = true
+		}
+	}
+}
+
+// reportWhitespace prints to stderr how the lines use white space
+func reportWhitespace(lines []*line) {
+	tabbed, spaced, mixed, trailing := 0, 0, 0, 0
+	for _, l := range lines {
+		switch {
+		case l.tabbed && l.spaced:
+			mixed++
+		case l.tabbed:
+			tabbed++
+		case l.spaced:
+			spaced++
+		}
+		if l.trailing {
+			trailing++
+		}
+	}
+
+	log.Printf("%d lines indented with tabs, %d with spaces, %d with both, %d with trailing white space",
+		tabbed, spaced, mixed, trailing)
+}
+
+// detectCode marks as verbatim the lines of the blocks, i.e runs of lines
+// separated by blank lines, that look like code
+func detectCode(lines []*line) {
+	for start := 0; start < len(lines); {
+		end := start
+		for end < len(lines) && !lines[end].blank {
+			end++
+		}
+
+		if block := lines[start:end]; len(block) > 0 && isCode(block) {
+			for _, l := range block {
+				l.verbatim = true
+			}
+		}
+
+		start = end + 1
+	}
+}
+
+// detectVerse marks as verbatim the blocks of at least 2 lines, like
+// poems or addresses, whose lines are all shorter than width and
+// do not end with a slash
+func detectVerse(lines []*line, width int) {
+	for start := 0; start < len(lines); {
+		end := start
+		for end < len(lines) && !lines[end].blank {
+			end++
+		}
+
+		if block := lines[start:end]; len(block) > 1 && isVerse(block, width) {
+			for _, l := range block {
+				l.verbatim = true
+			}
+		}
+
+		start = end + 1
+	}
+}
+
+// detectAligned marks as verbatim the adjacent lines that have a word at the
+// same column after two or more spaces, like aligned trailing comments
+func detectAligned(lines []*line) {
+	for i := 0; i+1 < len(lines); i++ {
+		next := alignedColumns(lines[i+1])
+		for col := range alignedColumns(lines[i]) {
+			if next[col] {
+				lines[i].verbatim = true
+				lines[i+1].verbatim = true
+				break
+			}
+		}
+	}
+}
+
+// alignedColumns returns the columns of l where a word starts after two or more spaces
+func alignedColumns(l *line) map[int]bool {
+	cols := make(map[int]bool)
+	if l.blank || l.tabular {
+		return cols
+	}
+	col, run := l.indent, 0 // run counts the spaces before col
+	for _, r := range l.text {
+		if r == ' ' {
+			run++
+		} else {
+			if run >= 2 {
+				cols[col] = true
+			}
+			run = 0
+		}
+		col++
+	}
+	return cols
+}
+
+// keepShort marks as verbatim the paragraphs whose lines are all shorter than width
+func keepShort(lines []*line, width int) {
+	for start := 0; start < len(lines); {
+		end := start
+		short := true
+		for end < len(lines) && !lines[end].blank {
+			short = short && lines[end].indent+utf8.RuneCountInString(lines[end].text) < width
+			end++
+		}
+
+		if short {
+			for _, l := range lines[start:end] {
+				l.verbatim = true
+			}
+		}
+
+		start = end + 1
+	}
+}
+
+// isVerse reports whether all the lines of block are short and plain text
+func isVerse(block []*line, width int) bool {
+	for _, l := range block {
+		if l.incomplete || l.tabular || l.indent+utf8.RuneCountInString(l.text) >= width {
+			return false
+		}
+	}
+	return true
+}
+
+// isCode guesses whether a block of lines is code rather than prose. Each of
+// the following counts as a hint for code
+//   - more than 1 symbol, like braces, operators or semicolons, every 10 letters
+//   - most lines end with a brace, a parenthesis, a comma or a semicolon
+//   - lines after the first have different indentation
+//   - lines, except the last, are ragged, i.e some are less than half the longest
+//
+// Prose is wrapped to about the same length and mostly has words and punctuation,
+// so a block with at least 2 hints is considered code
+func isCode(block []*line) bool {
+	symbols, letters, endings := 0, 0, 0
+	indents := make(map[int]bool)
+	shortest, longest := -1, 0
+	for i, l := range block {
+		for _, r := range l.text {
+			if strings.ContainsRune("{}[]()<>=;+*/%&|!^~", r) {
+				symbols++
+			} else if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+
+		if t := strings.TrimSpace(l.text); t != "" && strings.ContainsAny(t[len(t)-1:], "{}[]();,") {
+			endings++
+		}
+
+		if i > 0 {
+			indents[l.indent] = true
+		}
+
+		if i < len(block)-1 {
+			n := utf8.RuneCountInString(l.text)
+			if shortest < 0 || n < shortest {
+				shortest = n
+			}
+			if n > longest {
+				longest = n
+			}
+		}
+	}
+
+	hints := 0
+	if symbols*10 > letters {
+		hints++
+	}
+	if endings*2 > len(block) {
+		hints++
+	}
+	if len(indents) > 1 {
+		hints++
+	}
+	if shortest >= 0 && shortest*2 < longest {
+		hints++
+	}
+
+	return hints >= 2
+}
+
+// reverse returns the lines in reverse order. Consecutive tabular lines form
+// a table which is moved as a whole, unless rows is set. Then the rows of the
+// table are reversed too, except the first one if header is set
+func reverse(lines []*line, rows, header bool) []*line {
+	reversed := make([]*line, 0, len(lines))
+	for end := len(lines); end > 0; {
+		start := end - 1
+		for start > 0 && lines[start].tabular && lines[start-1].tabular {
+			start--
+		}
+
+		block := lines[start:end]
+		if rows {
+			if header && block[0].tabular {
+				reversed = append(reversed, block[0])
+				block = block[1:]
+			}
+			for i := len(block) - 1; i >= 0; i-- {
+				reversed = append(reversed, block[i])
+			}
+		} else {
+			reversed = append(reversed, block...)
+		}
+		end = start
+	}
+
+	return reversed
+}
+
+var (
+	spaces = strings.Repeat(" ", 256)
+	ats    = strings.Repeat("@", 256)
+)
+
+// growIndentation makes spaces and ats long enough to indent lines by n,
+// like the lines indented by many levels of a large -indent-unit
+func growIndentation(n int) {
+	if n > len(spaces) {
+		spaces = strings.Repeat(" ", n)
+		ats = strings.Repeat("@", n)
+	}
+}
+
+// format fmts all the inputs lines and outputs to the buffer
+func format(lines []*line, buf *bytes.Buffer) {
+	if *fitMin && fittedLength > 0 && fittedLength < *length {
+		defer func(l int) { *length = l }(*length)
+		*length = fittedLength
+	}
+
+	for _, line := range lines {
+		if line.width > 0 {
+			defer func(l int) { *length = l }(*length)
+			*length = line.width
+			break
+		}
+	}
+
+	// with -mark-paragraphs, the marker is put before a paragraph, or table,
+	// that follows blank lines after the previous one
+	mark := func(l *line) {
+		if *markParagraphs == "" || l.blank {
+			marks.separated = marks.separated || marks.written
+			return
+		}
+		if marks.separated {
+			buf.WriteString(*markParagraphs + "\n")
+		}
+		marks.written, marks.separated = true, false
+	}
+
+	var table []*line
+	for i, line := range lines {
+		if line.width > 0 && *stripWidthComment {
+			continue
+		}
+
+		// with -table-blank keep, blank lines between rows are part of the table
+		keepBlank := line.blank && len(table) > 0 && *tableBlank == "keep" && continuesTable(lines[i+1:])
+		if isRow(line) || keepBlank {
+			if len(table) == 0 {
+				mark(line)
+			}
+			table = append(table, line)
+		} else {
+			formatTable(table, buf)
+			table = nil
+			mark(line)
+
+			if *filterCmd != "" && !line.verbatim && !line.blank {
+				filtered := *line
+				filtered.text = filter(line.text, *filterCmd)
+				line = &filtered
+			}
+
+			lim := *length
+			if *levelPrefix && !line.blank {
+				unit := *tabstop
+				if *indentUnit > 0 {
+					unit = *indentUnit
+				}
+				// the line is wrapped in the space left by the prefix
+				n, _ := fmt.Fprintf(buf, "[L%d] ", line.indent/unit)
+				*length -= n
+			}
+
+			start := buf.Len()
+			switch {
+			case line.verbatim && *rawVerbatim:
+				if markRaw {
+					buf.WriteString(rawMark)
+				}
+				buf.WriteString(line.rawText)
+			case line.verbatim:
+				buf.WriteString(line.prefix)
+				buf.WriteString(spaces[0:line.indent])
+				buf.WriteString(line.text)
+			case line.prefix != "":
+				buf.WriteString(formatPrefixed(line))
+			case line.blank:
+				// ignore
+			case line.attributed:
+				// align right
+				if n := utf8.RuneCountInString(line.text); n < *length {
+					buf.WriteString(strings.Repeat(" ", *length-n))
+				}
+				buf.WriteString(line.text)
+			case linkReference(line) != "":
+				// do not break the URL, but wrap a long title in the next lines
+				ref := linkReference(line)
+				title := strings.TrimSpace(line.text[len(ref):])
+				buf.WriteString(spaces[0:line.indent])
+				if title == "" || line.indent+utf8.RuneCountInString(line.text) <= *length {
+					buf.WriteString(line.text)
+				} else {
+					margin := line.indent + 4
+					buf.WriteString(ref + "\n")
+					buf.WriteString(text.Indent(wrap(title, *length-margin), spaces[0:margin]))
+				}
+			case listItem(line.text) != "":
+				// wrap the text after the marker and indent the rest of the lines under it
+				marker := listItem(line.text)
+				margin := line.indent + utf8.RuneCountInString(marker)
+				t := wrap(line.text[len(marker):], *length-margin)
+				buf.WriteString(spaces[0:line.indent])
+				buf.WriteString(marker)
+				buf.WriteString(strings.ReplaceAll(t, "\n", "\n"+spaces[0:margin]))
+			case *outline && line.indented:
+				buf.WriteString(hang(line.text, line.indent, *outlineIndent))
+			case line.quoted:
+				margin := *tabstop
+				if *indentUnit > 0 {
+					margin = *indentUnit
+				}
+				width := *length - margin*2
+				if *noIndentWrap {
+					width = *length
+				}
+				t := text.Indent(wrap(line.text, width), spaces[0:margin])
+				buf.WriteString(t)
+			case line.footnote > 0:
+				// wrap the text after the marker and indent the rest of the lines under it
+				margin := line.indent + line.footnote
+				t := wrap(line.text[line.footnote:], *length-margin)
+				buf.WriteString(spaces[0:line.indent])
+				buf.WriteString(line.text[0:line.footnote])
+				buf.WriteString(strings.ReplaceAll(t, "\n", "\n"+spaces[0:margin]))
+			case line.indented && *noIndentWrap:
+				buf.WriteString(spaces[0:line.indent])
+				buf.WriteString(wrap(line.text, *length))
+			case line.indented:
+				// add a prefix of @s as placeholder for the indentation, wrap and then remove it
+				t := wrap(ats[0:line.indent]+line.text, *length)
+				buf.WriteString(spaces[0:line.indent])
+				buf.WriteString(t[line.indent:])
+			default:
+				t := wrap(line.text, *length)
+				buf.WriteString(t)
+			}
+			buf.WriteRune('\n')
+			*length = lim
+
+			if *reportOverflow && !line.verbatim {
+				overflows(buf.String()[start:], line.num)
+			}
+			if !line.verbatim && line.prefix == "" && strings.Count(buf.String()[start:], "\n") > 1 {
+				changes.wrapped++
+			}
+		}
+	}
+
+	formatTable(table, buf)
+}
+
+// formatPrefixed wraps the text of a line in the space left by its prefix and
+// then adds the prefix to each output line. Tables are not wrapped
+func formatPrefixed(l *line) string {
+	switch {
+	case l.blank:
+		return strings.TrimRight(l.prefix, " \t")
+	case l.tabular:
+		return l.prefix + l.text
+	}
+	t := wrap(l.text, *length-utf8.RuneCountInString(l.prefix))
+	return l.prefix + strings.ReplaceAll(t, "\n", "\n"+l.prefix)
+}
+
+// overflows prints to stderr the longest word of each line of the
+// formatted text that is longer than -l. num is the input line number
+func overflows(formatted string, num int) {
+	for _, l := range strings.Split(formatted, "\n") {
+		if utf8.RuneCountInString(l) <= *length {
+			continue
+		}
+
+		longest := ""
+		for _, word := range strings.Fields(l) {
+			if utf8.RuneCountInString(word) > utf8.RuneCountInString(longest) {
+				longest = word
+			}
+		}
+		log.Printf("line %d: %q overflows the line length %d", num, longest, *length)
+	}
+}
+
+// hang wraps text with the first line indented by first spaces
+// and the rest of the lines indented by rest spaces
+func hang(text string, first, rest int) string {
+	growIndentation(rest)
+	if first < rest {
+		t := wrap(text, *length-rest)
+		return spaces[0:first] + strings.ReplaceAll(t, "\n", "\n"+spaces[0:rest])
+	}
+
+	// add a prefix of @s as placeholder for the extra indentation of the first line, wrap and then remove it
+	extra := first - rest
+	t := wrap(ats[0:extra]+text, *length-rest)
+	return spaces[0:first] + strings.ReplaceAll(t[extra:], "\n", "\n"+spaces[0:rest])
+}
+
+// filter returns the output of the shell command cmd with text as input.
+// If the command fails, it returns the text
+func filter(text, cmd string) string {
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdin, c.Stderr = strings.NewReader(text), os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		log.Printf("-filter %q: %v", cmd, err)
+		return text
+	}
+	return strings.TrimRight(string(out), "\n")
+}
+
+// isRow reports whether l is a row of a table
+func isRow(l *line) bool {
+	return l.tabular && !l.verbatim && l.prefix == ""
+}
+
+// continuesTable reports whether the first non blank line of lines is a row of a table
+func continuesTable(lines []*line) bool {
+	for _, l := range lines {
+		if !l.blank {
+			return isRow(l)
+		}
+	}
+	return false
+}
+
+// formatTable aligns the rows of a table with elastic tabstops and outputs to the buffer
+func formatTable(rows []*line, buf *bytes.Buffer) {
+	if len(rows) == 0 {
+		return
+	}
+	changes.tables++
+
+	texts := make([]string, 0, len(rows))
+	blanks := make([]bool, len(rows))
+	for i, row := range rows {
+		if row.blank {
+			blanks[i] = true
+			continue
+		}
+
+		text := row.text
+		if *emptyCells == "collapse" {
+			text = collapseCells(text)
+		}
+		texts = append(texts, text)
+	}
+
+	if *tableInfo {
+		if data, err := json.Marshal(describeTable(texts, rows[0].num, *header || *underlineHeader)); err == nil {
+			fmt.Fprintf(os.Stderr, "%s\n", data)
+		}
+	}
+
+	if *tableComments != "" {
+		alignComments(texts, *tableComments)
+	}
+	if *justifyTotal {
+		justifyLastRow(texts)
+	}
+
+	cellTabstop := *tabstop
+	if *tableTabstop > 0 {
+		cellTabstop = *tableTabstop
+	}
+	if *compact {
+		cellTabstop = 1
+	}
+
+	render := func(texts []string) string {
+		switch {
+		case *kvEquals:
+			return alignKeyValues(texts, " = ")
+		case *kvAlign:
+			return alignKeyValues(texts, " ")
+		case *leader != "":
+			return withLeaders(texts, cellTabstop, *leader)
+		case *elasticStrict:
+			return elastic(texts, cellTabstop, 1)
+		}
+
+		var table bytes.Buffer
+		tabw := tabwriter.NewWriter(&table, cellTabstop, cellTabstop, 1, ' ', 0)
+		for _, text := range texts {
+			tabw.Write([]byte(text + "\n"))
+		}
+		tabw.Flush()
+		return table.String()
+	}
+	table := render(texts)
+
+	width, maxWidth := textWidth(table), *length
+	if *tableLength > 0 {
+		maxWidth = *tableLength
+	}
+	if *tableLength > 0 && width > maxWidth {
+		log.Printf("table starting with %q is %d characters wide, longer than %d", rows[0].text, width, *tableLength)
+	}
+	asCSV := *overflowCSV && width > maxWidth
+	if asCSV {
+		table = toCSV(texts)
+	}
+
+	var rule string
+	if *underlineHeader && !asCSV {
+		// render the table again with the header marked to find its columns
+		marked := render(append([]string{markCells(texts[0])}, texts[1:]...))
+		rule = underline(splitCells(texts), strings.SplitN(marked, "\n", 2)[0])
+	}
+
+	// put back the blank rows
+	aligned := strings.SplitAfter(table, "\n")
+	for i, j := 0, 0; i < len(rows); i++ {
+		if blanks[i] {
+			buf.WriteByte('\n')
+			continue
+		}
+
+		buf.WriteString(aligned[j])
+		if j == 0 {
+			buf.WriteString(rule)
+		}
+		j++
+	}
+}
+
+// textWidth returns the number of characters of the longest line of s
+func textWidth(s string) int {
+	width := 0
+	for _, l := range strings.Split(s, "\n") {
+		if n := utf8.RuneCountInString(l); n > width {
+			width = n
+		}
+	}
+	return width
+}
+
+var (
+	trailingSpace = regexp.MustCompile(`(?m)[ \t]+$`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// compactText removes the trailing white space of lines of s, and the blank
+// lines at its start and end, and squeezes runs of blank lines into one
+func compactText(s string) string {
+	s = trailingSpace.ReplaceAllString(s, "")
+	s = blankLines.ReplaceAllString(s, "\n\n")
+	if s = strings.Trim(s, "\n"); s != "" {
+		s += "\n"
+	}
+	return s
+}
+
+// pad pads each line of s with spaces, or truncates it, to width characters
+func pad(s string, width int) string {
+	var b strings.Builder
+	for _, l := range strings.SplitAfter(s, "\n") {
+		if l == "" {
+			continue
+		}
+
+		r := []rune(strings.TrimSuffix(l, "\n"))
+		if len(r) > width {
+			r = r[0:width]
+		}
+		b.WriteString(string(r))
+		b.WriteString(strings.Repeat(" ", width-len(r)))
+		if strings.HasSuffix(l, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
 }
@@ -0,0 +1,1882 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// setFlags sets the flags, given as name, value pairs, for the test
+// and restores their values when it ends
+func setFlags(t *testing.T, flags ...string) {
+	t.Helper()
+	for i := 0; i+1 < len(flags); i += 2 {
+		f := flag.Lookup(flags[i])
+		if f == nil {
+			t.Fatalf("no flag -%s", flags[i])
+		}
+		old := f.Value.String()
+		if err := f.Value.Set(flags[i+1]); err != nil {
+			t.Fatalf("-%s %q: %v", flags[i], flags[i+1], err)
+		}
+		t.Cleanup(func() { f.Value.Set(old) })
+	}
+}
+
+// ted formats input with the flags and returns the output
+func ted(t *testing.T, input string, flags ...string) string {
+	t.Helper()
+	setFlags(t, flags...)
+
+	var buf bytes.Buffer
+	formatText(readlines(scanLines(strings.NewReader(input))), &buf)
+	finish(&buf)
+	return buf.String()
+}
+
+// captureLog returns the buffer where the log is written during the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// formatTest is a test of the output of ted for an input
+type formatTest struct {
+	name  string
+	input string
+	flags []string
+	want  string
+}
+
+// runFormatTests runs the tests with ted
+func runFormatTests(t *testing.T, tests []formatTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ted(t, tt.input, tt.flags...); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newLines returns the input lines with texts
+func newLines(texts ...string) []*line {
+	lines := make([]*line, len(texts))
+	for i, text := range texts {
+		lines[i] = newLine(text, false)
+	}
+	return lines
+}
+
+// formatTexts formats the input lines with texts and returns the output
+func formatTexts(texts ...string) string {
+	var buf bytes.Buffer
+	format(newLines(texts...), &buf)
+	return buf.String()
+}
+
+// testLines returns the lines of texts, which are tabular if they contain tabs
+func testLines(texts ...string) []*line {
+	lines := make([]*line, len(texts))
+	for i, text := range texts {
+		lines[i] = &line{text: text, tabular: strings.Contains(text, "\t")}
+	}
+	return lines
+}
+
+// lineTexts returns the texts of lines
+func lineTexts(lines []*line) []string {
+	texts := make([]string, len(lines))
+	for i, l := range lines {
+		texts[i] = l.text
+	}
+	return texts
+}
+
+func TestReverse(t *testing.T) {
+	tests := []struct {
+		name         string
+		texts        []string
+		rows, header bool
+		want         []string
+	}{
+		{"lines", []string{"one", "two", "three"}, false, false, []string{"three", "two", "one"}},
+		{"table moves as a whole", []string{"one", "a\t1", "b\t2", "three"}, false, false, []string{"three", "a\t1", "b\t2", "one"}},
+		{"rows", []string{"one", "a\t1", "b\t2"}, true, false, []string{"b\t2", "a\t1", "one"}},
+		{"rows keep the header first", []string{"one", "h\tv", "a\t1", "b\t2"}, true, true, []string{"h\tv", "b\t2", "a\t1", "one"}},
+		{"header without rows", []string{"one", "h\tv", "a\t1"}, false, true, []string{"h\tv", "a\t1", "one"}},
+		{"empty", nil, false, false, []string{}},
+	}
+	for _, tt := range tests {
+		got := lineTexts(reverse(testLines(tt.texts...), tt.rows, tt.header))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReverseFormat(t *testing.T) {
+	var buf bytes.Buffer
+	format(reverse(testLines("one", "a\t1", "b\t2", "three"), false, false), &buf)
+	if got, want := buf.String(), "three\na   1\nb   2\none\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandEscapes(t *testing.T) {
+	tests := []struct {
+		text       string
+		want       []string
+		incomplete bool
+	}{
+		{`plain`, []string{"plain"}, false},
+		{`one\ntwo`, []string{"one", "two"}, false},
+		{`a\tb`, []string{"a\tb"}, false},
+		{`back\\slash`, []string{`back\slash`}, false},
+		{`unknown \x`, []string{`unknown \x`}, false},
+		{`ends\n`, []string{"ends", ""}, false},
+		{`continued \`, []string{"continued "}, true},
+		{`escaped \\`, []string{`escaped \`}, false},
+	}
+	for _, tt := range tests {
+		texts, incomplete := expandEscapes(tt.text)
+		if !reflect.DeepEqual(texts, tt.want) || incomplete != tt.incomplete {
+			t.Errorf("expandEscapes(%q) = %q, %v, want %q, %v", tt.text, texts, incomplete, tt.want, tt.incomplete)
+		}
+	}
+}
+
+func TestNewLine(t *testing.T) {
+	tests := []struct {
+		text                   string
+		want                   string
+		indent                 int
+		blank, tabular, quoted bool
+	}{
+		{"plain", "plain", 0, false, false, false},
+		{"  two", "two", 2, false, false, false},
+		{"\tquoted", "quoted", 4, false, false, true},
+		{"a\tb", "a\tb", 0, false, true, false},
+		{" \t ", "", 5, true, false, false},
+	}
+	for _, tt := range tests {
+		l := newLine(tt.text, false)
+		if l.text != tt.want || l.indent != tt.indent || l.blank != tt.blank || l.tabular != tt.tabular || l.quoted != tt.quoted {
+			t.Errorf("newLine(%q) = %+v", tt.text, *l)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"abc\nab\n", 3},
+		{"αβγδ\nab", 4},
+	}
+	for _, tt := range tests {
+		if got := textWidth(tt.s); got != tt.want {
+			t.Errorf("textWidth(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTableLength(t *testing.T) {
+	logged := captureLog(t)
+	setFlags(t, "l", "10", "table-length", "12")
+	want := "some words\nhere\nalpha beta gamma\n"
+	if got := formatTexts("some words here", "alpha\tbeta\tgamma"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !strings.Contains(logged.String(), "16 characters wide, longer than 12") {
+		t.Errorf("no warning for the wide table, log: %q", logged)
+	}
+
+	logged.Reset()
+	setFlags(t, "table-length", "20")
+	formatTexts("some words here", "alpha\tbeta\tgamma")
+	if logged.Len() > 0 {
+		t.Errorf("warning for a table that fits: %q", logged)
+	}
+}
+
+func TestIsCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  bool
+	}{
+		{"c", "if (x) {\n    y = f(a, b);\n}", true},
+		{"go", "func main() {\n\tfmt.Println(x[0] + 1)\n}", true},
+		{"prose", "This is some prose that is long enough\nto be joined with the next line here,\nand it has only words and punctuation.", false},
+		{"one line", "Just a sentence.", false},
+	}
+	for _, tt := range tests {
+		if got := isCode(newLines(strings.Split(tt.block, "\n")...)); got != tt.want {
+			t.Errorf("%s: isCode = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectCode(t *testing.T) {
+	texts := []string{"if (x) {", "    y = f(a, b);", "}", "", "This is some prose that is long enough to be wrapped here."}
+	lines := newLines(texts...)
+	detectCode(lines)
+	for i, want := range []bool{true, true, true, false, false} {
+		if lines[i].verbatim != want {
+			t.Errorf("line %d %q: verbatim = %v, want %v", i+1, texts[i], lines[i].verbatim, want)
+		}
+	}
+
+	setFlags(t, "l", "30")
+	var buf bytes.Buffer
+	format(lines, &buf)
+	want := "if (x) {\n    y = f(a, b);\n}\n\nThis is some prose that is\nlong enough to be wrapped\nhere.\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSmartDetect(t *testing.T) {
+	input := "if (x) {\n    y = f(a, b);\n}\n\nThis is some prose that is long enough\nto be joined with the next line here.\n"
+	runFormatTests(t, []formatTest{
+		{
+			name:  "code is kept",
+			input: input,
+			flags: []string{"l", "30", "rewrap", "true", "smart-detect", "true"},
+			want:  "if (x) {\n    y = f(a, b);\n}\n\nThis is some prose that is\nlong enough to be joined with\nthe next line here.\n",
+		},
+		{
+			name:  "without -smart-detect",
+			input: input,
+			flags: []string{"l", "30", "rewrap", "true"},
+			want:  "if (x) {\n    y = f(a, b); }\n\nThis is some prose that is\nlong enough to be joined with\nthe next line here.\n",
+		},
+	})
+}
+
+func TestWhitespaceReport(t *testing.T) {
+	logged := captureLog(t)
+	reportWhitespace(newLines("\ttabs", "    spaces", " \tboth", "trailing ", "none"))
+	want := "1 lines indented with tabs, 1 with spaces, 1 with both, 1 with trailing white space\n"
+	if got := logged.String(); got != want {
+		t.Errorf("got report %q, want %q", got, want)
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	next := scanLines(strings.NewReader("one\n\nthree"))
+	var got []string
+	for text, eof := next(); !eof; text, eof = next() {
+		got = append(got, text)
+	}
+	if want := []string{"one", "", "three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandEscapesFlag(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"lines", `one\ntwo` + "\n", []string{"expand-escapes", "true"}, "one\ntwo\n"},
+		{"table", `a\t1\nbb\t2` + "\n", []string{"expand-escapes", "true"}, "a   1\nbb  2\n"},
+		{"without -expand-escapes", `one\ntwo` + "\n", nil, `one\ntwo` + "\n"},
+	})
+}
+
+func TestEditFormatted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the editor appends a paragraph longer than the line length
+	editor := filepath.Join(dir, "editor")
+	script := "#!/bin/sh\nprintf '\\n%s\\n' \"" + strings.Repeat("word ", 6) + "\" >> \"$1\"\n"
+	if err := ioutil.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+	os.Setenv("EDITOR", editor)
+
+	// the tests have no terminal, so the editor uses a file instead
+	defer func(tty string) { terminal = tty }(terminal)
+	terminal = filepath.Join(dir, "tty")
+	if err := ioutil.WriteFile(terminal, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlags(t, "l", "20")
+	buf := bytes.NewBufferString("Some text\n")
+	if err := editFormatted(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Some text\n\nword word word word\nword word\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// exported tables are not formatted again
+	setFlags(t, "export", "csv")
+	buf = bytes.NewBufferString("a,b\n")
+	if err := editFormatted(buf); err != nil {
+		t.Fatal(err)
+	}
+	want = "a,b\n\n" + strings.Repeat("word ", 6) + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("-export: got %q, want %q", got, want)
+	}
+
+	// commit messages are formatted again as commit messages, not to -l
+	setFlags(t, "export", "", "git", "true")
+	buf = bytes.NewBufferString("Subject\n\nBody\n")
+	if err := editFormatted(buf); err != nil {
+		t.Fatal(err)
+	}
+	want = "Subject\n\nBody\n\n" + strings.Repeat("word ", 5) + "word\n"
+	if got := buf.String(); got != want {
+		t.Errorf("-git: got %q, want %q", got, want)
+	}
+
+	os.Setenv("EDITOR", "")
+	if err := editFormatted(buf); err == nil {
+		t.Errorf("no error without $EDITOR")
+	}
+}
+
+func TestEditTerminal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(tty string) { terminal = tty }(terminal)
+	terminal = filepath.Join(dir, "tty")
+	if err := ioutil.WriteFile(terminal, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+
+	// the editor talks to the terminal, not to the output of ted
+	editor := filepath.Join(dir, "editor")
+	if err := ioutil.WriteFile(editor, []byte("#!/bin/sh\necho editing\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("EDITOR", editor)
+	edited, err := edit([]byte("text\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(edited) != "text\n" {
+		t.Errorf("edited %q, want %q", edited, "text\n")
+	}
+	if data, _ := ioutil.ReadFile(terminal); string(data) != "editing\n" {
+		t.Errorf("terminal got %q, want %q", data, "editing\n")
+	}
+
+	// a failing editor, like vi with :cq, cancels the edit
+	failing := filepath.Join(dir, "failing")
+	if err := ioutil.WriteFile(failing, []byte("#!/bin/sh\necho changed > \"$1\"\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("EDITOR", failing)
+	if edited, err := edit([]byte("text\n")); err == nil {
+		t.Errorf("edit with a failing editor = %q, want error", edited)
+	}
+
+	// without a terminal there is nobody to edit
+	os.Setenv("EDITOR", editor)
+	terminal = filepath.Join(dir, "missing")
+	if _, err := edit([]byte("text\n")); err == nil {
+		t.Errorf("no error without a terminal")
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	tests := []struct {
+		l, r string
+		want string
+	}{
+		{"end.", "Next", "  "},
+		{"end?)", "Next", "  "},
+		{"word", "next", " "},
+		{"hyph-", "enated", ""},
+		{"éé-", "bar", ""},
+		{"a-", "ébar", ""},
+		{"1-", "2", " "},
+		{"-", "dash", " "},
+		{"", "next", ""},
+	}
+	setFlags(t, "smart-join-space", "true")
+	for _, tt := range tests {
+		if got := separator(tt.l, tt.r); got != tt.want {
+			t.Errorf("separator(%q, %q) = %q, want %q", tt.l, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestSmartJoinSpace(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"no space at line edges", "aaaa bbbb.\ncccc dddd eeee.\nffff\n", []string{"l", "12", "j", "true", "smart-join-space", "true"},
+			"aaaa bbbb.\ncccc dddd\neeee.  ffff\n"},
+		{"hyphenated", "a hyph-\nenated word\n", []string{"j", "true", "smart-join-space", "true"}, "a hyph-enated word\n"},
+		{"without -smart-join-space", "end.\nNext hyph-\nenated\n", []string{"j", "true"}, "end. Next hyph- enated\n"},
+		{"accented hyphenated", "éé-\\\nbar\n", []string{"smart-join-space", "true"}, "éé-bar\n"},
+		{"two spaces kept at line end without -smart-join-space", "aaaa bbbb  cccc dddd\n", []string{"l", "10"}, "aaaa bbbb \ncccc dddd\n"},
+	})
+}
+
+func TestCopyToClipboard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+
+	os.Setenv("PATH", dir)
+	if err := copyToClipboard([]byte("text\n")); err == nil {
+		t.Errorf("no error without a clipboard command")
+	}
+
+	// the fake clipboard commands save their input to a file
+	clipboard := filepath.Join(dir, "clipboard")
+	script := "#!/bin/sh\ncat > " + clipboard + "\n"
+	for _, name := range []string{"xclip", "pbcopy", "clip.exe"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	if err := copyToClipboard([]byte("text\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(clipboard); err != nil || string(got) != "text\n" {
+		t.Errorf("clipboard has %q, %v, want %q", got, err, "text\n")
+	}
+}
+
+func TestFinalNewline(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"ensure, no blank line", "text\n", []string{"final-newline", "ensure"}, "text\n"},
+		{"ensure, one blank line", "text\n\n", []string{"final-newline", "ensure"}, "text\n"},
+		{"ensure, blank lines", "text\n\n\n\n", []string{"final-newline", "ensure"}, "text\n"},
+		{"strip, no blank line", "text\n", []string{"final-newline", "strip"}, "text"},
+		{"strip, one blank line", "text\n\n", []string{"final-newline", "strip"}, "text"},
+		{"strip, blank lines", "text\n\n\n\n", []string{"final-newline", "strip"}, "text"},
+		{"unset", "text\n\n\n", nil, "text\n\n\n"},
+		{"ensure, empty", "", []string{"final-newline", "ensure"}, ""},
+	})
+}
+
+func TestPad(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"ab\n", 4, "ab  \n"},
+		{"abcdef\n", 4, "abcd\n"},
+		{"ab\n\ncd", 3, "ab \n   \ncd "},
+		{"äöü\n", 4, "äöü \n"},
+		{"", 4, ""},
+	}
+	for _, tt := range tests {
+		if got := pad(tt.s, tt.width); got != tt.want {
+			t.Errorf("pad(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestFixed(t *testing.T) {
+	input := "some words that are wrapped\n\n\tindented with a tab\nalpha\tbeta\nαβγ δεζ\nalongwordthatislongerthanthelimit\n"
+	out := ted(t, input, "l", "12", "fixed", "true")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) < 6 {
+		t.Fatalf("got %d lines, want at least 6: %q", len(lines), out)
+	}
+	for _, l := range lines {
+		if n := utf8.RuneCountInString(l); n != 12 {
+			t.Errorf("line %q is %d characters wide, want 12", l, n)
+		}
+	}
+}
+
+func TestFormatStart(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"table", "a\tb\ncc\td\nprose\n", nil, "a   b\ncc  d\nprose\n"},
+		{"prose", "prose\na\tb\n", nil, "prose\na   b\n"},
+		{"blank line", "\nprose\n", nil, "\nprose\n"},
+		{"blank lines and a table", "\n\na\tb\n", nil, "\n\na   b\n"},
+		{"only a table", "a\tb\n", nil, "a   b\n"},
+	})
+}
+
+func TestNoIndentWrap(t *testing.T) {
+	input := "\ttabbed one two three four five\n"
+	runFormatTests(t, []formatTest{
+		{
+			name:  "indentation counts",
+			input: input,
+			flags: []string{"l", "20"},
+			want:  "    tabbed one\n    two three\n    four five\n",
+		},
+		{
+			name:  "text is -l wide",
+			input: input,
+			flags: []string{"l", "20", "no-indent-wrap", "true"},
+			want:  "    tabbed one two three\n    four five\n",
+		},
+	})
+}
+
+func TestFormatTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	long := "one two three four five six\n"
+	wrapped := "one two three\nfour five six\n"
+	files := map[string]string{
+		"a.txt":           long,
+		"sub/b.txt":       long,
+		"sub/c.md":        long,
+		".git/d.txt":      long,
+		"sub/.hide/e.txt": long,
+		"binary.txt":      "one\x00two\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setFlags(t, "l", "15")
+	if err := formatTree(dir, ""); err == nil {
+		t.Errorf("no error without -glob")
+	}
+	if err := formatTree(dir, "*.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"a.txt":           wrapped,
+		"sub/b.txt":       wrapped,
+		"sub/c.md":        long,
+		".git/d.txt":      long,
+		"sub/.hide/e.txt": long,
+		"binary.txt":      "one\x00two\n",
+	}
+	for name, data := range want {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%s: got %q, want %q", name, got, data)
+		}
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "indented continuation",
+			input: "[1] A footnote\n    that is long enough to wrap.\n[12] Another\n",
+			flags: []string{"l", "20", "footnotes", "true"},
+			want:  "[1] A footnote that\n    is long enough\n    to wrap.\n[12] Another\n",
+		},
+		{
+			name:  "joined footnotes stay apart",
+			input: "[1] A footnote\nthat is long enough to wrap.\n[12] Another\ncontinued here.\n",
+			flags: []string{"l", "20", "j", "true", "footnotes", "true"},
+			want:  "[1] A footnote that\n    is long enough\n    to wrap.\n[12] Another\n     continued here.\n",
+		},
+		{
+			name:  "references in the text",
+			input: "Text with a note[1] that is wrapped.\n",
+			flags: []string{"l", "20", "footnotes", "true"},
+			want:  "Text with a note[1]\nthat is wrapped.\n",
+		},
+		{
+			name:  "without -footnotes",
+			input: "[1] A footnote that is long enough to wrap.\n",
+			flags: []string{"l", "20"},
+			want:  "[1] A footnote that\nis long enough to\nwrap.\n",
+		},
+	})
+}
+
+func TestMaxJoin(t *testing.T) {
+	continued := strings.Repeat("word\\\n", 99) + "word\n"
+	runFormatTests(t, []formatTest{
+		{"continued lines", "a\\\nb\\\nc\\\nd\\\ne\\\nf\\\ng\n", []string{"maxjoin", "3"}, "a b c\nd e f\ng\n"},
+		{"with -j", "a\nb\nc\nd\ne\n", []string{"maxjoin", "2", "j", "true"}, "a b\nc d\ne\n"},
+		{"many continued lines", continued, []string{"maxjoin", "50", "l", "1000"}, strings.Repeat("word ", 49) + "word\n" + strings.Repeat("word ", 49) + "word\n"},
+		{"no limit", continued, []string{"l", "1000"}, strings.Repeat("word ", 99) + "word\n"},
+	})
+}
+
+func TestWarnMixed(t *testing.T) {
+	logged := captureLog(t)
+	input := "ok\n \tspace and tab\n\t tab and space\n    spaces\n\ttab\n"
+	ted(t, input, "warn-mixed", "true")
+	for _, want := range []string{"line 2: indentation mixes", "line 3: indentation mixes"} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("no warning %q, log: %q", want, logged)
+		}
+	}
+	if n := strings.Count(logged.String(), "\n"); n != 2 {
+		t.Errorf("got %d warnings, want 2, log: %q", n, logged)
+	}
+
+	logged.Reset()
+	ted(t, input, "warn-mixed", "false")
+	if logged.Len() > 0 {
+		t.Errorf("warnings without -warn-mixed: %q", logged)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	at80 := "The quick brown fox jumps over the lazy dog and keeps running through the fields\n" +
+		"until the sun goes down behind the hills, where it finally rests for the night.\n"
+	at72 := "The quick brown fox jumps over the lazy dog and keeps running through\n" +
+		"the fields until the sun goes down behind the hills, where it finally\n" +
+		"rests for the night.\n"
+	runFormatTests(t, []formatTest{
+		{"80 to 72", at80, []string{"l", "72", "rewrap", "true"}, at72},
+		{"72 to 80", at72, []string{"l", "80", "rewrap", "true"}, at80},
+		{"paragraphs", at80 + "\n" + at80, []string{"l", "72", "rewrap", "true"}, at72 + "\n" + at72},
+		{"tables", "short\nlines\na\tb\nc\td\nmore\n", []string{"rewrap", "true"}, "short lines\na   b\nc   d\nmore\n"},
+		{"without -rewrap", at72, []string{"l", "80"}, at72},
+	})
+}
+
+func TestFilter(t *testing.T) {
+	logged := captureLog(t)
+	tests := []struct {
+		text, cmd string
+		want      string
+	}{
+		{"hello world", "tr a-z A-Z", "HELLO WORLD"},
+		{"hello world", "tr -d o", "hell wrld"},
+		{"hello world", "exit 1", "hello world"},
+	}
+	for _, tt := range tests {
+		if got := filter(tt.text, tt.cmd); got != tt.want {
+			t.Errorf("filter(%q, %q) = %q, want %q", tt.text, tt.cmd, got, tt.want)
+		}
+	}
+	if !strings.Contains(logged.String(), `-filter "exit 1"`) {
+		t.Errorf("no error for the failed command, log: %q", logged)
+	}
+}
+
+func TestFilterFlag(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "paragraphs",
+			input: "hello world\n\nsecond one\n",
+			flags: []string{"filter", "tr a-z A-Z"},
+			want:  "HELLO WORLD\n\nSECOND ONE\n",
+		},
+		{
+			name:  "wrapped after filtering",
+			input: "hello world\n",
+			flags: []string{"l", "8", "filter", "sed 's/o/oooo/g'"},
+			want:  "helloooo\nwoooorld\n",
+		},
+		{
+			name:  "tables are not filtered",
+			input: "a\tb\n",
+			flags: []string{"filter", "tr a-z A-Z"},
+			want:  "a   b\n",
+		},
+	})
+}
+
+func TestHang(t *testing.T) {
+	tests := []struct {
+		text        string
+		first, rest int
+		want        string
+	}{
+		{"one two three four", 4, 0, "    one two\nthree four"},
+		{"one two three four", 0, 4, "one two\n    three\n    four"},
+		{"one two three four", 2, 2, "  one two\n  three four"},
+	}
+	setFlags(t, "l", "12")
+	for _, tt := range tests {
+		if got := hang(tt.text, tt.first, tt.rest); got != tt.want {
+			t.Errorf("hang(%q, %d, %d) = %q, want %q", tt.text, tt.first, tt.rest, got, tt.want)
+		}
+	}
+}
+
+func TestOutline(t *testing.T) {
+	input := "Top level item that is long\n\tNested item that is long too\n\t\tDeeper item that wraps too\n"
+	runFormatTests(t, []formatTest{
+		{
+			name:  "flush left",
+			input: input,
+			flags: []string{"l", "20", "outline", "true"},
+			want:  "Top level item that\nis long\n    Nested item that\nis long too\n        Deeper item\nthat wraps too\n",
+		},
+		{
+			name:  "continuation indent",
+			input: input,
+			flags: []string{"l", "20", "outline", "true", "outline-indent", "2"},
+			want:  "Top level item that\nis long\n    Nested item that\n  is long too\n        Deeper item\n  that wraps too\n",
+		},
+	})
+
+	// wider than the indentation buffers
+	defer func(s, a string) { spaces, ats = s, a }(spaces, ats)
+	runFormatTests(t, []formatTest{
+		{"past 256 columns", "Top\n\tNested item\n", []string{"l", "20", "outline", "true", "outline-indent", "300"},
+			"Top\n    Nested\n" + strings.Repeat(" ", 300) + "item\n"},
+	})
+}
+
+func TestReportOverflow(t *testing.T) {
+	logged := captureLog(t)
+	input := "see https://example.com/long now\nok\n\nanother verylongidentifier\n"
+	ted(t, input, "l", "10", "report-overflow", "true")
+	want := `line 1: "https://example.com/long" overflows the line length 10` + "\n" +
+		`line 4: "verylongidentifier" overflows the line length 10` + "\n"
+	if got := logged.String(); got != want {
+		t.Errorf("got report %q, want %q", got, want)
+	}
+
+	logged.Reset()
+	ted(t, input, "l", "30", "report-overflow", "true")
+	if logged.Len() > 0 {
+		t.Errorf("report for lines that fit: %q", logged)
+	}
+}
+
+func TestAttribution(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "em dash",
+			input: "To be or not to be, that is the question.\n— Shakespeare\n",
+			flags: []string{"l", "30", "attribution", "true"},
+			want:  "To be or not to be, that is\nthe question.\n" + strings.Repeat(" ", 17) + "— Shakespeare\n",
+		},
+		{
+			name:  "not joined",
+			input: "To be or not\nto be.\n-- Someone\n",
+			flags: []string{"l", "30", "j", "true", "attribution", "true"},
+			want:  "To be or not to be.\n" + strings.Repeat(" ", 20) + "-- Someone\n",
+		},
+		{
+			name:  "without -attribution",
+			input: "To be or not\nto be.\n-- Someone\n",
+			flags: []string{"l", "30", "j", "true"},
+			want:  "To be or not to be. -- Someone\n",
+		},
+		{
+			name:  "no text before",
+			input: "-- Someone\n",
+			flags: []string{"l", "30", "attribution", "true"},
+			want:  "-- Someone\n",
+		},
+		{
+			name:  "text after",
+			input: "He said no.\n— and then he left\nthe room.\n",
+			flags: []string{"l", "40", "j", "true", "attribution", "true"},
+			want:  "He said no. — and then he left the room.\n",
+		},
+		{
+			name:  "blank line after",
+			input: "He said no.\n— Someone\n\nMore text.\n",
+			flags: []string{"l", "20", "attribution", "true"},
+			want:  "He said no.\n" + strings.Repeat(" ", 11) + "— Someone\n\nMore text.\n",
+		},
+	})
+}
+
+func TestIsArt(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"+------+", true},
+		{"| box  |", true},
+		{"┌──┐", true},
+		{"  \\_/  ", true},
+		{"=====", true},
+		{"some text", false},
+		{"a - b", false},
+		{"x = a + b", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isArt(tt.text); got != tt.want {
+			t.Errorf("isArt(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPreserveArt(t *testing.T) {
+	box := "+------+\n| box  |\n+------+\n"
+	runFormatTests(t, []formatTest{
+		{"box", box + "some text\nmore text\n", []string{"j", "true", "preserve-art", "true"}, box + "some text more text\n"},
+		{"box drawing", "┌──┐\n│ab│\n└──┘\n", []string{"j", "true", "preserve-art", "true"}, "┌──┐\n│ab│\n└──┘\n"},
+		{"without -preserve-art", box, []string{"j", "true"}, "+------+ | box  | +------+\n"},
+	})
+}
+
+func TestLevelPrefix(t *testing.T) {
+	input := "top paragraph text\n\n\tlevel one text that wraps\n\n\t\tlevel two\na\tb\n"
+	runFormatTests(t, []formatTest{
+		{
+			name:  "levels",
+			input: input,
+			flags: []string{"l", "20", "level-prefix", "true"},
+			want:  "[L0] top paragraph\ntext\n\n[L1]     level\n    one\n    text\n    that\n    wraps\n\n[L2]     level\n    two\na   b\n",
+		},
+		{
+			name:  "short lines",
+			input: "top\n\n\tlevel one\n",
+			flags: []string{"l", "22", "level-prefix", "true"},
+			want:  "[L0] top\n\n[L1]     level one\n",
+		},
+		{
+			name:  "without -level-prefix",
+			input: "top\n\n\tlevel one\n",
+			flags: []string{"l", "20"},
+			want:  "top\n\n    level one\n",
+		},
+	})
+}
+
+func TestWidthComment(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "go",
+			input: "// ted-width: 10\n// note\n\none two three four\n",
+			flags: []string{"width-comment", "true"},
+			want:  "// ted-width: 10\n// note\n\none two\nthree four\n",
+		},
+		{
+			name:  "shell",
+			input: "#!/bin/sh\n#ted-width:10\n\none two three four\n",
+			flags: []string{"width-comment", "true"},
+			want:  "#!/bin/sh\n#ted-width:10\n\none two\nthree four\n",
+		},
+		{
+			name:  "strip",
+			input: "#!/bin/sh\n# ted-width: 10\n\none two three four\n",
+			flags: []string{"width-comment", "true", "strip-width-comment", "true"},
+			want:  "#!/bin/sh\n\none two\nthree four\n",
+		},
+		{
+			name:  "after the comment block",
+			input: "one\n// ted-width: 10\none two three four\n",
+			flags: []string{"width-comment", "true"},
+			want:  "one\n// ted-width: 10\none two three four\n",
+		},
+		{
+			name:  "without -width-comment",
+			input: "// ted-width: 10\none two three four\n",
+			want:  "// ted-width: 10\none two three four\n",
+		},
+	})
+
+	if *length != 120 {
+		t.Errorf("-l is %d after formatting, want 120", *length)
+	}
+}
+
+func TestWordFrequencies(t *testing.T) {
+	input := "The cat and the dog.\nThe dog's bone, the cat's toy.\n"
+	runFormatTests(t, []formatTest{
+		{
+			name:  "sorted by count",
+			input: input,
+			flags: []string{"wordfreq", "true"},
+			want:  "the   4\nand   1\nbone  1\ncat   1\ncat's 1\ndog   1\ndog's 1\ntoy   1\n",
+		},
+	})
+
+	stopwords["the"], stopwords["and"] = true, true
+	defer func() { stopwords = make(map[string]bool) }()
+	runFormatTests(t, []formatTest{
+		{
+			name:  "stopwords",
+			input: input,
+			flags: []string{"wordfreq", "true"},
+			want:  "bone  1\ncat   1\ncat's 1\ndog   1\ndog's 1\ntoy   1\n",
+		},
+	})
+}
+
+func TestResolveOverstrike(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"plain", "plain"},
+		{"ab\rcd", "cd"},
+		{"abc\rx", "xbc"},
+		{"x\b_", "_"},
+		{"b\bbo\bol\bld\bd", "bold"},
+		{"abc\r  X", "abX"},
+		{"\bx", "x"},
+		{"a\r\r   b", "a  b"},
+	}
+	for _, tt := range tests {
+		if got := resolveOverstrike(tt.text); got != tt.want {
+			t.Errorf("resolveOverstrike(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestOverstrike(t *testing.T) {
+	input := "b\bbo\bol\bld\bd text\n"
+	runFormatTests(t, []formatTest{
+		{"resolve", input, []string{"overstrike", "resolve"}, "bold text\n"},
+		{"keep", input, []string{"overstrike", "keep"}, input},
+	})
+}
+
+func TestCompactText(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"a\nb\n", "a\nb\n"},
+		{"a  \nb\t\n", "a\nb\n"},
+		{"\n\na\n\n\n\nb\n\n\n", "a\n\nb\n"},
+		{"a\n  \n\t\nb", "a\n\nb\n"},
+		{"\n \n", ""},
+	}
+	for _, tt := range tests {
+		if got := compactText(tt.s); got != tt.want {
+			t.Errorf("compactText(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"squeeze", "\n\none  two\n\n\n\nthree \n\n", []string{"compact", "true"}, "one two\n\nthree\n"},
+		{"tables", "a\tb\nccc\td\n", []string{"compact", "true"}, "a   b\nccc d\n"},
+	})
+}
+
+func TestWithPrefix(t *testing.T) {
+	tests := []struct {
+		text     string
+		want     string
+		prefix   string
+		verbatim bool
+	}{
+		{"# comment", "comment", "# ", false},
+		{"#comment", "comment", "#", false},
+		{"#   indented", "indented", "#   ", false},
+		{"  # comment", "comment", "  # ", false},
+		{"#", "", "#", false},
+		{"code", "code", "", true},
+	}
+	for _, tt := range tests {
+		l := withPrefix(newLine(tt.text, false), "#")
+		if l.text != tt.want || l.prefix != tt.prefix || l.verbatim != tt.verbatim {
+			t.Errorf("withPrefix(%q) = %q, prefix %q, verbatim %v, want %q, %q, %v",
+				tt.text, l.text, l.prefix, l.verbatim, tt.want, tt.prefix, tt.verbatim)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "comments are wrapped",
+			input: "# a comment that is long enough to wrap\n#\n#   indented comment that wraps too\n",
+			flags: []string{"l", "20", "prefix", "#"},
+			want:  "# a comment that\n# is long enough to\n# wrap\n#\n#   indented comment\n#   that wraps too\n",
+		},
+		{
+			name:  "the rest is as is",
+			input: "code that is not a comment at all here\n    # indented prefix text that wraps\n",
+			flags: []string{"l", "20", "prefix", "#"},
+			want:  "code that is not a comment at all here\n    # indented\n    # prefix text\n    # that wraps\n",
+		},
+		{
+			name:  "joined",
+			input: "# one two\n# three\ncode\n",
+			flags: []string{"l", "20", "j", "true", "prefix", "#"},
+			want:  "# one two three\ncode\n",
+		},
+		{
+			name:  "filtered once",
+			input: "// a b\n",
+			flags: []string{"prefix", "//", "filter", "sed s/a/aa/"},
+			want:  "// aa b\n",
+		},
+		{
+			name:  "level once",
+			input: "// a b\n",
+			flags: []string{"prefix", "//", "level-prefix", "true"},
+			want:  "[L0] // a b\n",
+		},
+	})
+}
+
+func TestNarrowest(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  int
+	}{
+		{"paragraph", "one two three\nfour five\nsix", 9},
+		{"last lines are not counted", "one two three\nfour\n\nfive six seven\neight", 13},
+		{"indentation counts", "    one two\n    three four five\nsix", 11},
+		{"tables are not counted", "a\tb\nc\td\none two\nthree four", 7},
+		{"single lines", "one\n\ntwo", 0},
+	}
+	for _, tt := range tests {
+		var lines []*line
+		for _, text := range strings.Split(tt.lines, "\n") {
+			lines = append(lines, newLine(text, false))
+		}
+		if got := narrowest(lines); got != tt.want {
+			t.Errorf("%s: narrowest = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFitMin(t *testing.T) {
+	input := "one two three\nfour five\nsix seven eight nine ten\n"
+	runFormatTests(t, []formatTest{
+		{"narrowest line", input, []string{"j", "true", "fit-min", "true"}, "one two\nthree\nfour five\nsix seven\neight\nnine ten\n"},
+		{"-l is narrower", input, []string{"l", "5", "j", "true", "fit-min", "true"}, "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"},
+	})
+}
+
+func TestStream(t *testing.T) {
+	input := "  one two three\n\n\n\nfour five  \n\ta\tb\nc\td\n  six\n\n\n"
+	tests := [][]string{
+		nil,
+		{"compact", "true"},
+		{"final-newline", "strip"},
+		{"final-newline", "ensure"},
+		{"fixed", "true", "l", "10"},
+		{"show-indent", "true", "compact", "true"},
+		{"line-template", "%2d: ", "final-newline", "strip"},
+		{"line-template", "%d:%d "},
+		{"eol", "dos", "compact", "true"},
+		{"mark-paragraphs", "%%"},
+		{"mark-paragraphs", "%%", "compact", "true"},
+	}
+	for _, flags := range tests {
+		t.Run(strings.Join(flags, " "), func(t *testing.T) {
+			want := ted(t, input, flags...)
+
+			var buf bytes.Buffer
+			if err := formatStream(scanLines(strings.NewReader(input)), &buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != want {
+				t.Errorf("got %q, want %q like without -stream", got, want)
+			}
+		})
+	}
+}
+
+// chanWriter sends each write to the channel
+type chanWriter chan string
+
+func (c chanWriter) Write(p []byte) (int, error) {
+	c <- string(p)
+	return len(p), nil
+}
+
+func TestStreamIncremental(t *testing.T) {
+	setFlags(t, "l", "20")
+	r, w := io.Pipe()
+	out := make(chanWriter)
+	done := make(chan error, 1)
+	go func() {
+		done <- formatStream(scanLines(r), out)
+		close(out)
+	}()
+
+	// each paragraph is written before the next one is sent
+	for _, p := range []struct{ in, want string }{
+		{"first paragraph\nwrapped\n\n", "first paragraph\nwrapped\n\n"},
+		{"second\n\n", "second\n\n"},
+	} {
+		if _, err := io.WriteString(w, p.in); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-out:
+			if got != p.want {
+				t.Errorf("wrote %q, want %q", got, p.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q is not written before the next paragraph is sent", p.want)
+		}
+	}
+
+	w.Close()
+	var rest string
+	for s := range out {
+		rest += s
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rest != "" {
+		t.Errorf("wrote %q at the end, want nothing", rest)
+	}
+}
+
+func TestCheckStream(t *testing.T) {
+	tests := []struct {
+		flags []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{"compact", "true", "fixed", "true"}, true},
+		{[]string{"table-blank", "break"}, true},
+		{[]string{"reverse", "true"}, false},
+		{[]string{"export", "csv"}, false},
+		{[]string{"math", "true"}, false},
+		{[]string{"html", "true"}, false},
+		{[]string{"context-indent", "true"}, false},
+		{[]string{"git", "true"}, false},
+		{[]string{"md", "true"}, false},
+		{[]string{"table-blank", "keep"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.flags, " "), func(t *testing.T) {
+			setFlags(t, tt.flags...)
+			if err := checkStream(); (err == nil) != tt.ok {
+				t.Errorf("checkStream() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckRecursive(t *testing.T) {
+	tests := []struct {
+		flags []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{"l", "40", "j", "true"}, true},
+		{[]string{"reverse", "true"}, false},
+		{[]string{"wordfreq", "true"}, false},
+		{[]string{"export", "json"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.flags, " "), func(t *testing.T) {
+			setFlags(t, tt.flags...)
+			if err := checkRecursive(); (err == nil) != tt.ok {
+				t.Errorf("checkRecursive() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestConvertEOL(t *testing.T) {
+	tests := []struct {
+		eol, s, want string
+	}{
+		{"unix", "a\nb\n", "a\nb\n"},
+		{"dos", "a\nb\n", "a\r\nb\r\n"},
+		{"mac", "a\nb\n", "a\rb\r"},
+		{"dos", "a\n\nb", "a\r\n\r\nb"},
+	}
+	for _, tt := range tests {
+		setFlags(t, "eol", tt.eol)
+		buf := bytes.NewBufferString(tt.s)
+		convertEOL(buf)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("-eol %s: convertEOL(%q) = %q, want %q", tt.eol, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEOL(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"dos", "one two\n\na\tb\n", []string{"l", "4", "eol", "dos"}, "one\r\ntwo\r\n\r\na   b\r\n"},
+		{"mac", "one two\n", []string{"l", "4", "eol", "mac"}, "one\rtwo\r"},
+	})
+}
+
+func TestIsVerse(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  bool
+	}{
+		{"poem", "Roses are red,\nviolets are blue", true},
+		{"long line", "Roses are red,\nviolets are blue and this line is long", false},
+		{"table", "Roses\tred\nviolets\tblue", false},
+		{"continued", "Roses are red,\\\nviolets are blue", false},
+	}
+	for _, tt := range tests {
+		var block []*line
+		for _, text := range strings.Split(tt.block, "\n") {
+			text, incomplete := continued(text)
+			block = append(block, newLine(text, incomplete))
+		}
+		if got := isVerse(block, 20); got != tt.want {
+			t.Errorf("%s: isVerse = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerse(t *testing.T) {
+	poem := "Roses are red,\nviolets are blue,\nsugar is sweet.\n"
+	prose := "This line of prose is long enough\nto be joined with the next one.\n"
+	runFormatTests(t, []formatTest{
+		{"poem", poem, []string{"l", "40", "j", "true", "verse", "true"}, poem},
+		{"prose", prose, []string{"l", "40", "j", "true", "verse", "true"}, "This line of prose is long enough to be\njoined with the next one.\n"},
+		{"without -verse", poem, []string{"l", "40", "j", "true"}, "Roses are red, violets are blue, sugar\nis sweet.\n"},
+		{"ratio", poem, []string{"l", "40", "j", "true", "verse", "true", "verse-ratio", "0.25"}, "Roses are red, violets are blue, sugar\nis sweet.\n"},
+	})
+}
+
+func TestCheckClobber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing")
+	if err := ioutil.WriteFile(existing, []byte("text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name  string
+		file  string
+		flags []string
+		ok    bool
+	}{
+		{"existing", existing, []string{"no-clobber", "true"}, false},
+		{"missing", missing, []string{"no-clobber", "true"}, true},
+		{"forced", existing, []string{"no-clobber", "true", "force", "true"}, true},
+		{"appended", existing, []string{"no-clobber", "true", "a", "true"}, true},
+		{"without -no-clobber", existing, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.flags...)
+			if err := checkClobber(tt.file); (err == nil) != tt.ok {
+				t.Errorf("checkClobber(%s) = %v, want ok %v", tt.name, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDedent(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  int
+	}{
+		{"common", "        one\n\n            two", 8},
+		{"tabs", "\tone\n\t\ttwo", 4},
+		{"none", "one\n    two", 0},
+	}
+	setFlags(t, "context-indent", "true")
+	for _, tt := range tests {
+		var lines []*line
+		for _, text := range strings.Split(tt.lines, "\n") {
+			lines = append(lines, newLine(text, false))
+		}
+		if got := dedent(lines); got != tt.want {
+			t.Errorf("%s: dedent = %d, want %d", tt.name, got, tt.want)
+		}
+		if tt.want > 0 && lines[0].indent != 0 {
+			t.Errorf("%s: first line indented by %d after dedent", tt.name, lines[0].indent)
+		}
+	}
+}
+
+func TestContextIndent(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "region",
+			input: "        one two three four five six\n        seven\n\n            nested text here\n",
+			flags: []string{"l", "24", "context-indent", "true"},
+			want:  "        one two three\n        four five six\n        seven\n\n            nested text\n        here\n",
+		},
+		{
+			name:  "without -context-indent",
+			input: "        one two three four five six\n",
+			flags: []string{"l", "24"},
+			want:  "        one two three\nfour five six\n",
+		},
+	})
+}
+
+func TestShowIndentation(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"  a b\n", "··a b\n"},
+		{"\ta\n", "→a\n"},
+		{" \t a  b \n", "·→·a  b \n"},
+		{"a\n\n", "a\n\n"},
+	}
+	for _, tt := range tests {
+		if got := showIndentation(tt.s); got != tt.want {
+			t.Errorf("showIndentation(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestShowIndent(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"indented", "\tindented\n  spaced\n", []string{"show-indent", "true"}, "····indented\n··spaced\n"},
+		{"raw tabs", "\t+--+\n", []string{"show-indent", "true", "raw-verbatim", "true", "preserve-art", "true"}, "→+--+\n"},
+	})
+}
+
+func TestSplitLiteral(t *testing.T) {
+	tests := []struct {
+		args, flags, literal []string
+	}{
+		{[]string{"-l", "20", "file"}, []string{"-l", "20", "file"}, nil},
+		{[]string{"-l", "20", "--", "some text", "more"}, []string{"-l", "20"}, []string{"some text", "more"}},
+		{[]string{"--", "a", "--", "b"}, []string{}, []string{"a", "--", "b"}},
+		{[]string{"-j", "--"}, []string{"-j"}, []string{}},
+	}
+	for _, tt := range tests {
+		flags, literal := splitLiteral(tt.args)
+		if !reflect.DeepEqual(flags, tt.flags) || !reflect.DeepEqual(literal, tt.literal) {
+			t.Errorf("splitLiteral(%q) = %q, %q, want %q, %q", tt.args, flags, literal, tt.flags, tt.literal)
+		}
+		if (literal == nil) != (tt.literal == nil) {
+			t.Errorf("splitLiteral(%q): literal is nil %v, want %v", tt.args, literal == nil, tt.literal == nil)
+		}
+	}
+}
+
+func TestHardBreak(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"html", "one<br>\ntwo\nthree\n", []string{"j", "true", "hardbreak", "<br>"}, "one<br>\ntwo three\n"},
+		{"markdown", "one  \ntwo\nthree\n", []string{"j", "true", "hardbreak", "  "}, "one  \ntwo three\n"},
+		{"wrapped", "one two three  \nfour\n", []string{"l", "8", "j", "true", "hardbreak", "  "}, "one two\nthree  \nfour\n"},
+		{"continued", "one<br>\\\ntwo\n", []string{"hardbreak", "<br>"}, "one<br>\ntwo\n"},
+		{"without -hardbreak", "one<br>\ntwo\n", []string{"j", "true"}, "one<br> two\n"},
+	})
+}
+
+func TestMarkParagraphs(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"paragraphs", "one\n\ntwo\n\n\nthree\na\tb\n", []string{"mark-paragraphs", "%%"}, "one\n\n%%\ntwo\n\n\n%%\nthree\na   b\n"},
+		{"blank lines at the ends", "\none\n\n", []string{"mark-paragraphs", "%%"}, "\none\n\n"},
+		{"unmarked again", "one\n\n%%\ntwo\n", []string{"unmark", "%%"}, "one\n\ntwo\n"},
+	})
+}
+
+func TestAlignedColumns(t *testing.T) {
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"x = 1     # one", []int{10}},
+		{"  a  b   c", []int{5, 9}},
+		{"single spaces only", nil},
+		{"a\tb", nil},
+	}
+	for _, tt := range tests {
+		var got []int
+		for col := range alignedColumns(newLine(tt.text, false)) {
+			got = append(got, col)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("alignedColumns(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPreserveAligned(t *testing.T) {
+	comments := "x = 1     # one\nyy = 2    # two\n"
+	runFormatTests(t, []formatTest{
+		{"aligned", comments + "some text\nmore text\n", []string{"j", "true", "preserve-aligned", "true"}, comments + "some text more text\n"},
+		{"not aligned", "x = 1    # one\nyy = 2    # two\n", []string{"j", "true", "preserve-aligned", "true"}, "x = 1    # one yy = 2    # two\n"},
+		{"without -preserve-aligned", comments, []string{"j", "true"}, "x = 1     # one yy = 2    # two\n"},
+	})
+}
+
+func TestNowrapBelow(t *testing.T) {
+	input := "short one\nshort two\n\na longer line of text here\nshort\n"
+	runFormatTests(t, []formatTest{
+		{"short paragraphs", input, []string{"rewrap", "true", "nowrap-below", "20"}, "short one\nshort two\n\na longer line of text here short\n"},
+		{"without -nowrap-below", input, []string{"rewrap", "true"}, "short one short two\n\na longer line of text here short\n"},
+		{"all short", input, []string{"rewrap", "true", "nowrap-below", "30"}, input},
+	})
+}
+
+func TestListItem(t *testing.T) {
+	setFlags(t, "lists", "true")
+	tests := []struct {
+		text, want string
+	}{
+		{"- item", "- "},
+		{"*  item", "*  "},
+		{"1. item", "1. "},
+		{"10) item", "10) "},
+		{"• item", "• "},
+		{"-item", ""},
+		{"1.5 is a number", ""},
+		{"text", ""},
+	}
+	for _, tt := range tests {
+		if got := listItem(tt.text); got != tt.want {
+			t.Errorf("listItem(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLists(t *testing.T) {
+	nested := "- first level item with some text\n" +
+		"  1. second level item with text\n" +
+		"     - third level item with text\n" +
+		"  10. another second level item\n" +
+		"- back\n"
+	runFormatTests(t, []formatTest{
+		{"nested", nested, []string{"lists", "true", "l", "22"},
+			"- first level item\n" +
+				"  with some text\n" +
+				"  1. second level item\n" +
+				"     with text\n" +
+				"     - third level\n" +
+				"       item with text\n" +
+				"  10. another second\n" +
+				"      level item\n" +
+				"- back\n"},
+		{"items are not joined", "- one\n- two\n", []string{"lists", "true", "j", "true"}, "- one\n- two\n"},
+		{"without -lists", "- first level item with some text\n", []string{"l", "22"}, "- first level item\nwith some text\n"},
+	})
+}
+
+func TestReindent(t *testing.T) {
+	tests := []struct {
+		s, indent, want string
+	}{
+		{"a\nb\n", "  ", "  a\n  b\n"},
+		{"a\n\nb\n", "  ", "  a\n\n  b\n"},
+		{"a\n" + rawMark + "\t  raw\n", "  ", "  a\n\t  raw\n"},
+		{"[L0] " + rawMark + " raw\n", "  ", "[L0]  raw\n"},
+	}
+	for _, tt := range tests {
+		if got := reindent(tt.s, tt.indent); got != tt.want {
+			t.Errorf("reindent(%q, %q) = %q, want %q", tt.s, tt.indent, got, tt.want)
+		}
+	}
+}
+
+func TestRawVerbatim(t *testing.T) {
+	input := "    some words here\n  \t+--+\n    |  |\n"
+	runFormatTests(t, []formatTest{
+		{"as read", input, []string{"l", "14", "preserve-art", "true", "raw-verbatim", "true"},
+			"    some words\nhere\n  \t+--+\n    |  |\n"},
+		{"normalized", input, []string{"l", "14", "preserve-art", "true"},
+			"    some words\nhere\n    +--+\n    |  |\n"},
+		{"with -context-indent", input, []string{"l", "14", "preserve-art", "true", "raw-verbatim", "true", "context-indent", "true"},
+			"    some words\n    here\n  \t+--+\n    |  |\n"},
+	})
+}
+
+func TestCommitMessage(t *testing.T) {
+	long := "Fix the race in the file watcher that loses events on startup\n" +
+		"The watcher is started before the handlers are registered so the first events of a busy directory are dropped and never delivered to anyone.\n"
+	runFormatTests(t, []formatTest{
+		{"long subject and body", long, []string{"git", "true"},
+			"Fix the race in the file watcher that loses events\n\n" +
+				"on startup\n\n" +
+				"The watcher is started before the handlers are registered so the first\n" +
+				"events of a busy directory are dropped and never delivered to anyone.\n"},
+		{"blank lines around the subject", "\n\nShort subject\n\n\nbody\n", []string{"git", "true"}, "Short subject\n\nbody\n"},
+		{"subject only", "Short subject\n", []string{"git", "true"}, "Short subject\n"},
+		{"comments", "Subject\n\n# Please enter the commit message for your changes. Lines starting with '#' will be ignored.\n", []string{"git", "true"},
+			"Subject\n\n# Please enter the commit message for your changes. Lines starting with '#' will be ignored.\n"},
+		{"-l is ignored", "Subject\nbody text\n", []string{"git", "true", "l", "5"}, "Subject\n\nbody text\n"},
+	})
+}
+
+func TestParseLineList(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{"3", []int{3}, false},
+		{"3,5-7", []int{3, 5, 6, 7}, false},
+		{" 2 , 4 - 5 ", []int{2, 4, 5}, false},
+		{"1-1", []int{1}, false},
+		{"0", nil, true},
+		{"7-5", nil, true},
+		{"x", nil, true},
+		{"1-", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		lines := make(map[int]bool)
+		err := parseLineList(tt.list, lines)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLineList(%q) error = %v, want error %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		var got []int
+		for n := range lines {
+			got = append(got, n)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLineList(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestKeepLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		kept  []int
+		input string
+		flags []string
+		want  string
+	}{
+		{"one line", []int{2}, "one two\nthree   four\nfive six\n", []string{"l", "40", "j", "true"}, "one two\nthree   four\nfive six\n"},
+		{"without kept lines", nil, "one two\nthree   four\nfive six\n", []string{"l", "40", "j", "true"}, "one two three   four five six\n"},
+		{"long line", []int{1}, "a long line that is not wrapped\n", []string{"l", "10"}, "a long line that is not wrapped\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keptLines = make(map[int]bool)
+			defer func() { keptLines = make(map[int]bool) }()
+			for _, n := range tt.kept {
+				keptLines[n] = true
+			}
+			if got := ted(t, tt.input, tt.flags...); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamKeepLines(t *testing.T) {
+	keptLines[1], keptLines[5] = true, true
+	defer func() { keptLines = make(map[int]bool) }()
+
+	input := "one two three\n\nfour five six\n\nseven eight nine\n"
+	want := "one two three\n\nfour\nfive\nsix\n\nseven eight nine\n"
+	var buf bytes.Buffer
+	setFlags(t, "l", "6")
+	if err := formatStream(scanLines(strings.NewReader(input)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestKeepDangling(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"last line", "one\\\ntwo\\\n", []string{"l", "40", "keep-dangling", "true"}, "one two\\\n"},
+		{"last line without -keep-dangling", "one\\\ntwo\\\n", []string{"l", "40"}, "one two\n"},
+		{"before a blank line", "one \\\n\nthree\n", []string{"l", "40", "keep-dangling", "true"}, "one \\\n\nthree\n"},
+		{"before a blank line without -keep-dangling", "one \\\n\nthree\n", []string{"l", "40"}, "one\n\nthree\n"},
+		{"joined lines", "one\\\ntwo\n", []string{"l", "40", "keep-dangling", "true"}, "one two\n"},
+	})
+}
+
+func TestGrowIndentation(t *testing.T) {
+	defer func(s, a string) { spaces, ats = s, a }(spaces, ats)
+	tests := []struct {
+		n, want int
+	}{
+		{10, 256},
+		{256, 256},
+		{300, 300},
+		{200, 300},
+	}
+	for _, tt := range tests {
+		growIndentation(tt.n)
+		if len(spaces) != tt.want || len(ats) != tt.want {
+			t.Errorf("growIndentation(%d): len(spaces) = %d, len(ats) = %d, want %d", tt.n, len(spaces), len(ats), tt.want)
+		}
+	}
+}
+
+func TestIndentUnit(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"narrower", "top\n\n        two levels\n", []string{"l", "40", "indent-unit", "2"}, "top\n\n    two levels\n"},
+		{"wider", "top\n\n    one level\n", []string{"l", "40", "indent-unit", "8"}, "top\n\n        one level\n"},
+		{"past 256 columns", "            x\n", []string{"l", "40", "indent-unit", "100"}, strings.Repeat(" ", 300) + "x\n"},
+		{"level prefix", "top\n\n        two levels\n", []string{"l", "40", "indent-unit", "2", "level-prefix", "true"}, "[L0] top\n\n[L2]     two levels\n"},
+	})
+}
+
+func TestMarkMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text []string
+		want []bool
+	}{
+		{"fenced", []string{"text", "```go", "x := 1", "```", "text"}, []bool{false, true, true, true, false}},
+		{"unclosed fence", []string{"~~~", "code", "```", "code"}, []bool{true, true, true, true}},
+		{"indented code", []string{"text", "", "    code", "", "    more code", "text"}, []bool{false, false, true, false, true, false}},
+		{"indented continuation", []string{"text", "    not code"}, []bool{false, false}},
+		{"headings and rules", []string{"# Title", "#hashtag", "---", "* * *"}, []bool{true, false, true, true}},
+		{"setext", []string{"Title", "=====", "", "---"}, []bool{true, true, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make([]*line, len(tt.text))
+			for i, text := range tt.text {
+				lines[i] = newLine(text, false)
+			}
+			markMarkdown(lines)
+			for i, l := range lines {
+				if l.verbatim != tt.want[i] {
+					t.Errorf("line %d %q: verbatim = %v, want %v", i+1, tt.text[i], l.verbatim, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"paragraphs and code", "Some text that is long enough to wrap\n\n    code line one\n    code line two\n\nmore text\njoined here\n",
+			[]string{"l", "20", "md", "true"},
+			"Some text that is\nlong enough to wrap\n\n    code line one\n    code line two\n\nmore text joined\nhere\n"},
+		{"slash in code", "```\nmake \\\n  all\n```\n", []string{"l", "20", "md", "true"}, "```\nmake \\\n  all\n```\n"},
+		{"slash in indented code", "text\n\n    make \\\n      all\n", []string{"l", "20", "md", "true"}, "text\n\n    make \\\n      all\n"},
+		{"slash in art", "+--+ \\\ntext\n", []string{"l", "20", "preserve-art", "true"}, "+--+ \\\ntext\n"},
+		{"slash in text", "one\\\ntwo\n", []string{"l", "20", "md", "true"}, "one two\n"},
+	})
+}
+
+func TestKeepLinesSlash(t *testing.T) {
+	keptLines = map[int]bool{1: true}
+	defer func() { keptLines = make(map[int]bool) }()
+	if got, want := ted(t, "kept \\\nnext\n", "l", "20"), "kept \\\nnext\n"; got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestGutterWidth(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		n    int
+		want int
+	}{
+		{"%d| ", 1, 3},
+		{"%d| ", 10, 4},
+		{"%3d| ", 1, 5},
+		{"%3d| ", 999, 5},
+		{"%3d| ", 1000, 6},
+		{"%d → ", 7, 4},
+	}
+	for _, tt := range tests {
+		setFlags(t, "line-template", tt.tmpl)
+		if got := gutterWidth(tt.n); got != tt.want {
+			t.Errorf("gutterWidth(%d) with %q = %d, want %d", tt.n, tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestFormatGutter(t *testing.T) {
+	tests := []struct {
+		tmpl      string
+		n, indent int
+		want      string
+	}{
+		{"%3d| ", 7, 4, "  7| "},
+		{"%[2]d ", 7, 4, "4 "},
+		{"%d:%d ", 7, 4, "7:4 "},
+		{"%[2]d/%[1]d ", 7, 4, "4/7 "},
+		{"> ", 7, 4, "> "},
+		{"%%d ", 7, 4, "%d "},
+		{"%s", 7, 4, "%!s(int=7)"},
+		{"%d %d %d", 7, 4, "7 4 %!d(MISSING)"},
+	}
+	for _, tt := range tests {
+		if got := formatGutter(tt.tmpl, tt.n, tt.indent); got != tt.want {
+			t.Errorf("formatGutter(%q, %d, %d) = %q, want %q", tt.tmpl, tt.n, tt.indent, got, tt.want)
+		}
+	}
+}
+
+func TestIndentation(t *testing.T) {
+	tests := []struct {
+		l    string
+		want int
+	}{
+		{"text\n", 0},
+		{"    text\n", 4},
+		{"\t text\n", 2},
+		{"··→text\n", 3},
+		{"\n", 0},
+	}
+	for _, tt := range tests {
+		if got := indentation(tt.l); got != tt.want {
+			t.Errorf("indentation(%q) = %d, want %d", tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestLineTemplate(t *testing.T) {
+	input := "aaa bbb ccc ddd eee fff ggg hhh iii jjj kkk lll mmm nnn ooo ppp qqq rrr sss ttt uuu vvv\n"
+	words := strings.Fields(input)
+	var narrow, fixed strings.Builder
+	for i, w := range words {
+		fmt.Fprintf(&narrow, "%d| %s\n", i+1, w)
+	}
+	for i := 0; i < len(words); i += 2 {
+		fmt.Fprintf(&fixed, "%3d| %s %s\n", i/2+1, words[i], words[i+1])
+	}
+	// -l is already narrowed by the gutter of the first line, like main does
+	runFormatTests(t, []formatTest{
+		{"gutter grows", input, []string{"l", "7", "line-template", "%d| "}, narrow.String()},
+		{"fixed gutter", input, []string{"l", "7", "line-template", "%3d| "}, fixed.String()},
+		{"few lines", "aaa bbb\n", []string{"l", "7", "line-template", "%d| "}, "1| aaa bbb\n"},
+		{"no verb", "aaa bbb\n", []string{"l", "7", "line-template", "> "}, "> aaa bbb\n"},
+		{"indent", "top\n\n\tnext\n", []string{"l", "20", "line-template", "%[2]d "}, "0 top\n0 \n4     next\n"},
+		{"number and indent", "top\n\tnext\n", []string{"l", "20", "line-template", "%d:%d "}, "1:0 top\n2:4     next\n"},
+	})
+}
+
+func TestLinkReference(t *testing.T) {
+	tests := []struct {
+		text string
+		md   bool
+		want string
+	}{
+		{"[id]: http://example.com", true, "[id]: http://example.com"},
+		{`[id]: http://example.com "Title"`, true, "[id]: http://example.com"},
+		{"   [id]:http://example.com", true, "[id]:http://example.com"},
+		{"    [id]: http://example.com", true, ""},
+		{"[id]: ", true, ""},
+		{"[id] http://example.com", true, ""},
+		{"[id]: http://example.com", false, ""},
+	}
+	for _, tt := range tests {
+		setFlags(t, "md", fmt.Sprint(tt.md))
+		if got := linkReference(newLine(tt.text, false)); got != tt.want {
+			t.Errorf("linkReference(%q) with -md=%v = %q, want %q", tt.text, tt.md, got, tt.want)
+		}
+	}
+}
+
+func TestLinkReferences(t *testing.T) {
+	long := `[id]: http://example.com/a/very/long/path/that/overflows "A long title that must wrap here"` + "\n"
+	runFormatTests(t, []formatTest{
+		{"long url", "[id]: http://example.com/a/very/long/path/that/overflows\n", []string{"l", "20", "md", "true"},
+			"[id]: http://example.com/a/very/long/path/that/overflows\n"},
+		{"long title", long, []string{"l", "30", "md", "true"},
+			"[id]: http://example.com/a/very/long/path/that/overflows\n    \"A long title that must\n    wrap here\"\n"},
+		{"short", `[a]: /x "T"` + "\n", []string{"l", "30", "md", "true"}, `[a]: /x "T"` + "\n"},
+		{"not joined", "text\n[a]: /x\n[b]: /y\nmore\n", []string{"l", "30", "md", "true"}, "text\n[a]: /x\n[b]: /y\nmore\n"},
+		{"without -md", "[a]: http://example.com/long\n", []string{"l", "20"}, "[a]:\nhttp://example.com/long\n"},
+	})
+}
+
+func TestChangeCounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		flags []string
+		want  changeCounts
+	}{
+		{"nothing", "short\n", []string{"l", "20"}, changeCounts{}},
+		{"joined", "one\\\ntwo\\\nthree\n", []string{"l", "20"}, changeCounts{joined: 2}},
+		{"wrapped", "one two three four five\n\nsix\n", []string{"l", "10"}, changeCounts{wrapped: 1}},
+		{"tables", "a\tb\n\nc\td\n", []string{"l", "20"}, changeCounts{tables: 2}},
+		{"formatted again for the gutter", "aaa bbb ccc ddd eee fff ggg hhh iii jjj kkk lll mmm nnn ooo ppp qqq rrr sss ttt uuu vvv\n", []string{"l", "7", "line-template", "%d| "}, changeCounts{wrapped: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes = changeCounts{}
+			defer func() { changes = changeCounts{} }()
+			ted(t, tt.input, tt.flags...)
+			if changes != tt.want {
+				t.Errorf("got %+v, want %+v", changes, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeCountsEdit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(tty string) { terminal = tty }(terminal)
+	terminal = filepath.Join(dir, "tty")
+	if err := ioutil.WriteFile(terminal, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+	os.Setenv("EDITOR", "true")
+
+	changes = changeCounts{}
+	defer func() { changes = changeCounts{} }()
+	buf := bytes.NewBufferString(ted(t, "one\ntwo three\n", "j", "true", "l", "8"))
+	want := changeCounts{joined: 1, wrapped: 1}
+	if changes != want {
+		t.Fatalf("got %+v, want %+v", changes, want)
+	}
+
+	// the edited text is formatted again, but not counted
+	if err := editFormatted(buf); err != nil {
+		t.Fatal(err)
+	}
+	if changes != want {
+		t.Errorf("after -edit got %+v, want %+v", changes, want)
+	}
+}
+
+func TestChangeCountsReport(t *testing.T) {
+	logged := captureLog(t)
+	c := changeCounts{joined: 3, wrapped: 2, tables: 1}
+	c.report()
+	if got, want := logged.String(), "3 lines joined, 2 lines wrapped, 1 tables aligned\n"; got != want {
+		t.Errorf("report() logged %q, want %q", got, want)
+	}
+}
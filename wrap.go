@@ -0,0 +1,337 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kr/text"
+)
+
+// wrapPenalty is the penalty for lines longer than the limit, same as text.Wrap
+const wrapPenalty = 1e5
+
+// wrap wraps s into a paragraph of lines of length lim, like text.Wrap,
+// but does not break lines where the flags prohibit it
+func wrap(s string, lim int) string {
+	if *typography != "" {
+		s = typeset(s, *typography)
+	}
+	switch *dashSpacing {
+	case "tight":
+		s = spacedDash.ReplaceAllString(s, "—")
+	case "loose":
+		s = spacedDash.ReplaceAllString(s, " — ")
+	}
+
+	words := strings.Split(strings.ReplaceAll(strings.TrimSpace(s), "\n", " "), " ")
+	if *compact {
+		words = strings.Fields(s)
+	}
+	if *nobreakAfter != "" || *nobreakBefore != "" {
+		words = glue(words, func(prev, next string) bool {
+			return len([]rune(prev)) == 1 && strings.ContainsAny(prev, *nobreakAfter) ||
+				next != "" && strings.ContainsRune(*nobreakBefore, []rune(next)[0])
+		})
+	}
+	if *math {
+		words = glueMath(words, lim)
+	}
+	if *html {
+		words = glueTags(words, lim)
+	}
+	if *keepQuotes {
+		words = glueQuotes(words, lim)
+	}
+	if *dashSpacing == "loose" {
+		// do not end lines with a dash
+		words = glue(words, func(prev, next string) bool {
+			return prev == "—"
+		})
+	}
+	if *maxWord > 1 {
+		words = breakWords(words, *maxWord)
+	}
+
+	var wrapped string
+	if *semantic {
+		wrapped = wrapSentences(words, lim)
+	} else {
+		wrapped = wrapWords(words, lim)
+	}
+	if *hardBreak != "" && strings.HasSuffix(s, *hardBreak) && !strings.HasSuffix(wrapped, *hardBreak) {
+		// keep a hard break of white space, like the two spaces of markdown
+		wrapped += *hardBreak
+	}
+	return wrapped
+}
+
+// abbreviations are words that end with a period but usually do not end sentences
+var abbreviations = map[string]bool{
+	"e.g.": true, "i.e.": true, "cf.": true, "vs.": true, "Mr.": true, "Mrs.": true,
+	"Ms.": true, "Dr.": true, "Prof.": true, "St.": true, "No.": true, "Fig.": true,
+}
+
+// wrapSentences wraps the words into lines with one sentence per line.
+// Sentences longer than lim are wrapped like wrapWords
+func wrapSentences(words []string, lim int) string {
+	var lines []string
+	start := 0
+	for i, word := range words {
+		if i == len(words)-1 || endsSentence(word) {
+			lines = append(lines, wrapWords(words[start:i+1], lim))
+			start = i + 1
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// endsSentence reports whether word ends with . ! or ?, possibly followed
+// by closing quotes or parentheses, and is not an abbreviation or an initial
+func endsSentence(word string) bool {
+	w := strings.TrimRight(word, `"')]»”’`)
+	if !strings.HasSuffix(w, ".") && !strings.HasSuffix(w, "!") && !strings.HasSuffix(w, "?") {
+		return false
+	}
+	if i := strings.LastIndex(w, " "); i >= 0 {
+		w = w[i+1:] // the last of glued words
+	}
+	if abbreviations[w] {
+		return false
+	}
+	r := []rune(w)
+	return !(len(r) == 2 && unicode.IsUpper(r[0]) && r[1] == '.')
+}
+
+// breakMark marks the end of the parts of words broken by -maxword. It is output
+// as a hyphen, with no space after it. Input lines are C strings, so it is not
+// part of the text, unlike a soft hyphen
+const breakMark = "\x00"
+
+// breakWords breaks the words longer than n characters into parts of n
+// characters, including the hyphen at the end of each part but the last
+func breakWords(words []string, n int) []string {
+	broken := make([]string, 0, len(words))
+	for _, word := range words {
+		if strings.Contains(word, " ") {
+			// glued words are not broken
+			broken = append(broken, word)
+			continue
+		}
+		r := []rune(word)
+		for len(r) > n {
+			broken = append(broken, string(r[:n-1])+breakMark)
+			r = r[n-1:]
+		}
+		broken = append(broken, string(r))
+	}
+	return broken
+}
+
+// glue joins with a space the adjacent words for which nobreak is true,
+// so that they are wrapped as a single word
+func glue(words []string, nobreak func(prev, next string) bool) []string {
+	glued := make([]string, 0, len(words))
+	for i, word := range words {
+		if n := len(glued); n > 0 && nobreak(words[i-1], word) {
+			glued[n-1] += " " + word
+		} else {
+			glued = append(glued, word)
+		}
+	}
+	return glued
+}
+
+// glueMath joins with a space the words of inline math, $...$,
+// so that it is wrapped as a single word, unless it is longer than lim.
+// A $ that is not closed does not start math, like the price in "costs $5"
+func glueMath(words []string, lim int) []string {
+	glued := make([]string, 0, len(words))
+	var math []string
+	inMath := false
+	for _, word := range words {
+		open := inMath
+		for i := 0; i < len(word); i++ {
+			switch word[i] {
+			case '\\':
+				i++ // skip the escaped character, like \$
+			case '$':
+				inMath = !inMath
+			}
+		}
+
+		switch {
+		case inMath:
+			math = append(math, word)
+		case open:
+			glued = appendSpan(glued, append(math, word), lim)
+			math = nil
+		default:
+			glued = append(glued, word)
+		}
+	}
+	return append(glued, math...)
+}
+
+// glueTags joins with a space the words of HTML tags, <...>,
+// so that they are wrapped as a single word, unless they are longer than lim.
+// A < that is not closed by a > does not start a tag, like in x<y
+func glueTags(words []string, lim int) []string {
+	glued := make([]string, 0, len(words))
+	var tag []string
+	inTag := false
+	for _, word := range words {
+		open := inTag
+		for i := 0; i < len(word); i++ {
+			switch word[i] {
+			case '<':
+				// a tag, not a less than sign
+				inTag = i+1 < len(word) && (word[i+1] == '/' || unicode.IsLetter(rune(word[i+1])))
+			case '>':
+				inTag = false
+			}
+		}
+
+		switch {
+		case inTag:
+			tag = append(tag, word)
+		case open:
+			glued = appendSpan(glued, append(tag, word), lim)
+			tag = nil
+		default:
+			glued = append(glued, word)
+		}
+	}
+	return append(glued, tag...)
+}
+
+// glueQuotes joins with a space the words of quoted strings, "..." or '...',
+// so that they are wrapped as a single word, unless they are longer than lim.
+// Strings are quoted by a word that starts with a quote, so apostrophes inside
+// words are not quotes, and end at the next unescaped matching quote
+func glueQuotes(words []string, lim int) []string {
+	glued := make([]string, 0, len(words))
+	var quoted []string
+	var quote byte
+	for _, word := range words {
+		i := 0
+		if quote == 0 && word != "" && (word[0] == '"' || word[0] == '\'') {
+			quote = word[0]
+			i = 1
+		}
+		if quote == 0 {
+			glued = append(glued, word)
+			continue
+		}
+
+		quoted = append(quoted, word)
+		for ; i < len(word); i++ {
+			if word[i] == '\\' {
+				i++ // skip the escaped character, like \"
+			} else if word[i] == quote {
+				quote = 0
+				break
+			}
+		}
+		if quote == 0 {
+			glued = appendSpan(glued, quoted, lim)
+			quoted = nil
+		}
+	}
+	// an unterminated quote is not a string
+	return append(glued, quoted...)
+}
+
+// appendSpan appends the words of a span, like a quoted string, to glued,
+// as a single word if it fits in lim characters
+func appendSpan(glued, span []string, lim int) []string {
+	s := strings.Join(span, " ")
+	if utf8.RuneCountInString(s) > lim {
+		return append(glued, span...)
+	}
+	return append(glued, s)
+}
+
+// wrapWords wraps the words into lines of length lim with minimal raggedness.
+// Unlike text.Wrap, the length of words is measured in characters, not bytes
+func wrapWords(words []string, lim int) string {
+	for i := 0; i+1 < len(words); i++ {
+		if strings.HasSuffix(words[i], breakMark) {
+			// the parts of a broken word end their lines
+			return wrapWords(words[:i+1], lim) + "\n" + wrapWords(words[i+1:], lim)
+		}
+	}
+
+	// text.WrapWords measures the words and slices them into lines, so pass
+	// it placeholders of the right length and then slice the words the same
+	placeholders := make([][]byte, len(words))
+	for i, word := range words {
+		placeholders[i] = make([]byte, utf8.RuneCountInString(word))
+	}
+
+	var lines []string
+	i := 0
+	for _, l := range text.WrapWords(placeholders, 1, lim, wrapPenalty) {
+		line := words[i : i+len(l)]
+		i += len(l)
+
+		if *smartJoinSpace {
+			// the empty words of the two spaces after a sentence, put
+			// by separator, do not start or end lines
+			for len(line) > 0 && line[0] == "" {
+				line = line[1:]
+			}
+			for len(line) > 0 && line[len(line)-1] == "" {
+				line = line[:len(line)-1]
+			}
+			if len(line) == 0 {
+				continue
+			}
+		}
+		lines = append(lines, joinWords(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// joinWords joins the words of a line with spaces. The part of a word
+// broken by -maxword, which ends the line, is output with a hyphen
+func joinWords(words []string) string {
+	line := strings.Join(words, " ")
+	if strings.HasSuffix(line, breakMark) {
+		line = strings.TrimSuffix(line, breakMark) + "-"
+	}
+	return line
+}
+
+var spacedDash = regexp.MustCompile(`[ \t]*—[ \t]*`)
+
+var (
+	spaceBeforePunct     = regexp.MustCompile(`([^\s;:!?]) *([;:!?]+)(\s|$)`)
+	spaceAfterOpening    = regexp.MustCompile(`« *`)
+	spaceBeforeClosing   = regexp.MustCompile(` *»`)
+	spaceAfterOpeningDE  = regexp.MustCompile(`» *`)
+	spaceBeforeClosingDE = regexp.MustCompile(` *«`)
+)
+
+// typeset applies the typographic conventions of lang to the spacing of
+// punctuation. French puts a no-break space before ; : ! ? and inside « »,
+// English puts no space there and German puts no space inside » «, too.
+// No-break spaces are not white space for wrap, so lines are not broken there
+func typeset(s, lang string) string {
+	switch lang {
+	case "fr":
+		s = spaceBeforePunct.ReplaceAllString(s, "$1\u00a0$2$3")
+		s = spaceAfterOpening.ReplaceAllString(s, "«\u00a0")
+		s = spaceBeforeClosing.ReplaceAllString(s, "\u00a0»")
+	case "en":
+		s = spaceBeforePunct.ReplaceAllString(s, "$1$2$3")
+		s = spaceAfterOpening.ReplaceAllString(s, "«")
+		s = spaceBeforeClosing.ReplaceAllString(s, "»")
+	case "de":
+		s = spaceBeforePunct.ReplaceAllString(s, "$1$2$3")
+		s = spaceAfterOpeningDE.ReplaceAllString(s, "»")
+		s = spaceBeforeClosingDE.ReplaceAllString(s, "«")
+	}
+	return s
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGlue(t *testing.T) {
+	words := []string{"a", "cat", "in", "a", "house"}
+	got := glue(words, func(prev, next string) bool { return prev == "a" })
+	want := []string{"a cat", "in", "a house"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("glue = %q, want %q", got, want)
+	}
+}
+
+func TestNobreak(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		lim   int
+		flags []string
+		want  string
+	}{
+		{"after", "there is a cat in a house", 12, []string{"nobreak-after", "aA"}, "there is\na cat in\na house"},
+		{"before", "the answer : yes", 10, []string{"nobreak-before", ":"}, "the\nanswer :\nyes"},
+		{"none", "the answer : yes", 10, nil, "the answer\n: yes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.flags...)
+			if got := wrap(tt.s, tt.lim); got != tt.want {
+				t.Errorf("wrap(%q, %d) = %q, want %q", tt.s, tt.lim, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapWords(t *testing.T) {
+	tests := []struct {
+		words []string
+		lim   int
+		smart bool
+		want  string
+	}{
+		{[]string{"aaaa", "bbbb", "cccc"}, 9, false, "aaaa bbbb\ncccc"},
+		{[]string{"bbbb.", "", "cccc", "dddd"}, 10, true, "bbbb.\ncccc dddd"},
+		{[]string{"bbbb.", "", "cccc", "dddd"}, 10, false, "bbbb. \ncccc dddd"},
+		{[]string{"aa.", "", "bb"}, 20, true, "aa.  bb"},
+		{[]string{"ä", "ö", "ü"}, 3, false, "ä ö\nü"},
+		{[]string{"αβγ", "δεζ", "ηθι"}, 7, false, "αβγ δεζ\nηθι"},
+		{[]string{"xy", "abcd\x00", "ef", "gh"}, 20, false, "xy abcd-\nef gh"},
+	}
+	for _, tt := range tests {
+		setFlags(t, "smart-join-space", fmt.Sprint(tt.smart))
+		if got := wrapWords(tt.words, tt.lim); got != tt.want {
+			t.Errorf("wrapWords(%q, %d) with -smart-join-space=%v = %q, want %q", tt.words, tt.lim, tt.smart, got, tt.want)
+		}
+	}
+}
+
+func TestWrapNonASCII(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"ascii", "aaaa bbbb cccc\n", []string{"l", "9"}, "aaaa bbbb\ncccc\n"},
+		{"accents", "éééé èèèè àààà\n", []string{"l", "9"}, "éééé èèèè\nàààà\n"},
+		{"greek", "Καλή μέρα σας φίλοι\n", []string{"l", "13"}, "Καλή μέρα σας\nφίλοι\n"},
+	})
+}
+
+func TestTypeset(t *testing.T) {
+	tests := []struct {
+		s, lang string
+		want    string
+	}{
+		{"Quoi ? Non !", "fr", "Quoi\u00a0? Non\u00a0!"},
+		{"Quoi? Non; oui: si!", "fr", "Quoi\u00a0? Non\u00a0; oui\u00a0: si\u00a0!"},
+		{"«salut»", "fr", "«\u00a0salut\u00a0»"},
+		{"« salut »", "fr", "«\u00a0salut\u00a0»"},
+		{"Il dit : « oui » !", "fr", "Il dit\u00a0: «\u00a0oui\u00a0»\u00a0!"},
+		{"what ? no ; « yes »", "en", "what? no; «yes»"},
+		{"was ? » ja «", "de", "was? »ja«"},
+		{"http://example.com", "fr", "http://example.com"},
+	}
+	for _, tt := range tests {
+		if got := typeset(tt.s, tt.lang); got != tt.want {
+			t.Errorf("typeset(%q, %s) = %q, want %q", tt.s, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestTypography(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{
+			name:  "no break at no-break spaces",
+			input: "Il dit : « oui » !\n",
+			flags: []string{"l", "10", "typography", "fr"},
+			want:  "Il dit\u00a0:\n«\u00a0oui\u00a0»\u00a0!\n",
+		},
+		{
+			name:  "tables are not typeset",
+			input: "a ?\tb\n",
+			flags: []string{"typography", "fr"},
+			want:  "a ? b\n",
+		},
+	})
+}
+
+func TestDashSpacing(t *testing.T) {
+	input := "one two—three four — five\n"
+	runFormatTests(t, []formatTest{
+		{"tight", input, []string{"l", "12", "dash-spacing", "tight"}, "one\ntwo—three\nfour—five\n"},
+		{"loose", input, []string{"l", "12", "dash-spacing", "loose"}, "one two\n— three four\n— five\n"},
+		{"loose, no dash at line end", "aaaa bbb — c\n", []string{"l", "9", "dash-spacing", "loose"}, "aaaa bbb\n— c\n"},
+		{"unset", input, []string{"l", "40"}, "one two—three four — five\n"},
+	})
+}
+
+func TestGlueMath(t *testing.T) {
+	tests := []struct {
+		words, want []string
+	}{
+		{[]string{"let", "$a", "+", "b$", "hold"}, []string{"let", "$a + b$", "hold"}},
+		{[]string{"$x$", "and", "$y$"}, []string{"$x$", "and", "$y$"}},
+		{[]string{"cost", `\$5`, "and", `\$6`}, []string{"cost", `\$5`, "and", `\$6`}},
+		{[]string{"$a", `\$`, "b$", "c"}, []string{`$a \$ b$`, "c"}},
+		{[]string{"costs", "$5", "and", "more"}, []string{"costs", "$5", "and", "more"}},
+		{[]string{"$a", "+", "b", "+", "c$"}, []string{"$a", "+", "b", "+", "c$"}},
+	}
+	for _, tt := range tests {
+		if got := glueMath(tt.words, 8); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("glueMath(%q, 8) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestMath(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"inline", "let $a + b = c$ hold\n", []string{"l", "12", "math", "true"}, "let\n$a + b = c$\nhold\n"},
+		{"display", "$$\nx  =  y\n$$\ntext\n", []string{"j", "true", "math", "true"}, "$$\nx  =  y\n$$\ntext\n"},
+		{"escaped", "cost \\$5 and $x$\n", []string{"l", "12", "math", "true"}, "cost \\$5 and\n$x$\n"},
+		{"without -math", "let $a + b = c$ hold\n", []string{"l", "12"}, "let $a + b =\nc$ hold\n"},
+		{"unclosed", "It costs $5 and this sentence is long enough to wrap around\n", []string{"l", "20", "math", "true"},
+			"It costs $5 and\nthis sentence is\nlong enough to wrap\naround\n"},
+		{"longer than the line", "see $a + b + c + d$ here\n", []string{"l", "8", "math", "true"}, "see $a +\nb + c +\nd$ here\n"},
+	})
+}
+
+func TestBreakWords(t *testing.T) {
+	tests := []struct {
+		words []string
+		n     int
+		want  []string
+	}{
+		{[]string{"short", "words"}, 5, []string{"short", "words"}},
+		{[]string{"abcdefghijkl"}, 5, []string{"abcd\x00", "efgh\x00", "ijkl"}},
+		{[]string{"abcdef"}, 5, []string{"abcd\x00", "ef"}},
+		{[]string{"glued words"}, 5, []string{"glued words"}},
+		{[]string{"äöüäöü"}, 4, []string{"äöü\x00", "äöü"}},
+	}
+	for _, tt := range tests {
+		if got := breakWords(tt.words, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("breakWords(%q, %d) = %q, want %q", tt.words, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestMaxWord(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"fits", "abcdefghijkl xy\n", []string{"l", "20", "maxword", "5"}, "abcd-\nefgh-\nijkl xy\n"},
+		{"wrapped", "xy abcdefghijkl zz ww\n", []string{"l", "8", "maxword", "5"}, "xy abcd-\nefgh-\nijkl zz\nww\n"},
+		{"last part", "abcdefghij\n", []string{"l", "20", "maxword", "5"}, "abcd-\nefgh-\nij\n"},
+		{"soft hyphen", "soft\u00adhyphen\n", []string{"maxword", "20"}, "soft\u00adhyphen\n"},
+		{"soft hyphen without -maxword", "soft\u00adhyphen and more\n", []string{"l", "10"}, "soft\u00adhyphen\nand more\n"},
+	})
+}
+
+func TestGlueQuotes(t *testing.T) {
+	tests := []struct {
+		words []string
+		lim   int
+		want  []string
+	}{
+		{[]string{"say", `"hello`, `world"`, "now"}, 20, []string{"say", `"hello world"`, "now"}},
+		{[]string{"it's", "'a", "b'"}, 20, []string{"it's", "'a b'"}},
+		{[]string{`"a`, `\"b`, `c"`}, 20, []string{`"a \"b c"`}},
+		{[]string{`"too`, "long", `quoted"`}, 10, []string{`"too`, "long", `quoted"`}},
+		{[]string{`"open`, "never", "closed"}, 20, []string{`"open`, "never", "closed"}},
+	}
+	for _, tt := range tests {
+		if got := glueQuotes(tt.words, tt.lim); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("glueQuotes(%q, %d) = %q, want %q", tt.words, tt.lim, got, tt.want)
+		}
+	}
+}
+
+func TestKeepQuotes(t *testing.T) {
+	input := "he said \"hello there\" loudly\n"
+	runFormatTests(t, []formatTest{
+		{"kept", input, []string{"l", "14", "keep-quotes", "true"}, "he said\n\"hello there\"\nloudly\n"},
+		{"without -keep-quotes", input, []string{"l", "14"}, "he said \"hello\nthere\" loudly\n"},
+	})
+}
+
+func TestGlueTags(t *testing.T) {
+	tests := []struct {
+		words, want []string
+	}{
+		{[]string{"see", `<a`, `href="x">link</a>`, "now"}, []string{"see", `<a href="x">link</a>`, "now"}},
+		{[]string{"<img", `src="a"`, "/>"}, []string{`<img src="a" />`}},
+		{[]string{"a", "<", "b", "and", "c", ">", "d"}, []string{"a", "<", "b", "and", "c", ">", "d"}},
+		{[]string{"if", "x<y", "then", "z"}, []string{"if", "x<y", "then", "z"}},
+		{[]string{"<span", `class="a"`, `id="b">`}, []string{"<span", `class="a"`, `id="b">`}},
+	}
+	for _, tt := range tests {
+		if got := glueTags(tt.words, 20); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("glueTags(%q, 20) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"inline tags", "see <a href=\"x\">link</a> now\n", []string{"l", "22", "html", "true"}, "see\n<a href=\"x\">link</a>\nnow\n"},
+		{"longer than the line", "see <a href=\"x\">link</a> now\n", []string{"l", "12", "html", "true"}, "see <a\nhref=\"x\">link</a>\nnow\n"},
+		{"less than", "if x<y then the sentence goes on and on\n", []string{"l", "16", "html", "true"}, "if x<y then the\nsentence goes on\nand on\n"},
+		{"pre", "<pre>\na   b\n  c\n</pre>\ntext\nmore\n", []string{"j", "true", "html", "true"}, "<pre>\na   b\n  c\n</pre>\ntext more\n"},
+		{"code", "<code>x  =  1</code>\ntext\n", []string{"j", "true", "html", "true"}, "<code>x  =  1</code>\ntext\n"},
+		{"without -html", "see <a href=\"x\">link</a> now\n", []string{"l", "22"}, "see <a\nhref=\"x\">link</a> now\n"},
+	})
+}
+
+func TestEndsSentence(t *testing.T) {
+	tests := []struct {
+		word string
+		want bool
+	}{
+		{"end.", true},
+		{"really?", true},
+		{"wow!", true},
+		{`said."`, true},
+		{"(see above.)", true},
+		{"word", false},
+		{"comma,", false},
+		{"e.g.", false},
+		{"Dr.", false},
+		{"J.", false},
+		{"glued Mr.", false},
+		{"glued end.", true},
+	}
+	for _, tt := range tests {
+		if got := endsSentence(tt.word); got != tt.want {
+			t.Errorf("endsSentence(%q) = %v, want %v", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestWrapSentences(t *testing.T) {
+	tests := []struct {
+		words []string
+		lim   int
+		want  string
+	}{
+		{strings.Fields("One. Two words. Three!"), 40, "One.\nTwo words.\nThree!"},
+		{strings.Fields("Ask Dr. Who, e.g. now. Done"), 40, "Ask Dr. Who, e.g. now.\nDone"},
+		{strings.Fields("A sentence longer than the limit. Short."), 20, "A sentence longer\nthan the limit.\nShort."},
+	}
+	for _, tt := range tests {
+		if got := wrapSentences(tt.words, tt.lim); got != tt.want {
+			t.Errorf("wrapSentences(%q, %d) = %q, want %q", tt.words, tt.lim, got, tt.want)
+		}
+	}
+}
+
+func TestSemantic(t *testing.T) {
+	runFormatTests(t, []formatTest{
+		{"one sentence per line", "First sentence. Second one\nis here. Third!\n", []string{"l", "40", "j", "true", "semantic", "true"}, "First sentence.\nSecond one is here.\nThird!\n"},
+		{"without -semantic", "First sentence. Second one\nis here. Third!\n", []string{"l", "40", "j", "true"}, "First sentence. Second one is here.\nThird!\n"},
+	})
+}